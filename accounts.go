@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cosine/bulk"
+	"cosine/config"
+	"cosine/database"
+	"cosine/models"
+	"cosine/refresh"
+	"cosine/upstream"
+)
+
+const accountsUsage = `usage: cosine accounts <command> [options]
+
+commands:
+  list [-json] [-owner linuxdo_id] [-tag tag]
+  add -auth <cookie|-> -team <team_id> [-refresh-token token] [-weight n] [-tags a,b] [-owner linuxdo_id] [-upstream name] [-proxy url] [-no-probe] [-json]
+  disable <id>...
+  enable <id>...
+  set [-weight n] [-tags a,b] <id>...       (-tags "" clears the tags)
+  probe [-json] [id...]
+  refresh <id>...                            (renew session tokens now; needs token_refresh enabled)
+  import [-format csv|json] [-dry-run] [-no-probe] [-passphrase-file path] [-json] [file|-]
+  export [-format csv|json] [-encrypt] [-passphrase-file path] [-o file]
+
+Encrypted exports use the passphrase in -passphrase-file or $COSINE_EXPORT_PASSPHRASE.`
+
+// runAccounts implements the accounts subcommand
+func runAccounts(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal(accountsUsage)
+	}
+	if err := database.Open(&cfg.Database); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	err := accountsCommand(cfg, args[0], args[1:])
+	database.Close()
+	exitOnError(err)
+}
+
+func accountsCommand(cfg *config.Config, cmd string, args []string) error {
+	if err := upstream.Init(&cfg.Upstream); err != nil {
+		return fmt.Errorf("Failed to initialize upstream endpoints: %w", err)
+	}
+
+	switch cmd {
+	case "list":
+		return listAccounts(args)
+	case "add":
+		return addAccountCmd(args)
+	case "disable":
+		ids, err := parseIDs(args, accountsUsage)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := database.DeactivateAccount(id, models.DisabledByAdmin); err != nil {
+				return fmt.Errorf("Failed to disable account %d: %w", id, err)
+			}
+			fmt.Printf("Disabled account %d\n", id)
+		}
+		return nil
+	case "enable":
+		ids, err := parseIDs(args, accountsUsage)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := database.ActivateAccount(id); err != nil {
+				return fmt.Errorf("Failed to enable account %d: %w", id, err)
+			}
+			fmt.Printf("Enabled account %d\n", id)
+		}
+		return nil
+	case "set":
+		return setAccounts(args)
+	case "probe":
+		return probeAccounts(args)
+	case "refresh":
+		return refreshAccounts(args)
+	case "import":
+		return importAccounts(args)
+	case "export":
+		return exportAccounts(args)
+	default:
+		return errors.New(accountsUsage)
+	}
+}
+
+func listAccounts(args []string) error {
+	fs := flag.NewFlagSet("accounts list", flag.ContinueOnError)
+	asJSON := outputFlag(fs)
+	owner := fs.Int("owner", 0, "only accounts donated by this LinuxDo user")
+	tag := fs.String("tag", "", "only accounts carrying this tag")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var linuxdoID *int
+	if *owner != 0 {
+		linuxdoID = owner
+	}
+	accounts, err := database.ListAccounts(linuxdoID)
+	if err != nil {
+		return fmt.Errorf("Failed to list accounts: %w", err)
+	}
+	if *tag != "" {
+		accounts = slices.DeleteFunc(accounts, func(acc models.Account) bool {
+			return !slices.Contains(acc.Tags, *tag)
+		})
+	}
+	if *asJSON {
+		return printJSON(accounts)
+	}
+	printAccounts(accounts)
+	return nil
+}
+
+func setAccounts(args []string) error {
+	fs := flag.NewFlagSet("accounts set", flag.ContinueOnError)
+	weight := fs.Int("weight", 0, "share of requests relative to other accounts")
+	tags := fs.String("tags", "", "comma separated tags, replacing the current ones")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	setTags := false
+	fs.Visit(func(f *flag.Flag) { setTags = setTags || f.Name == "tags" })
+	if *weight == 0 && !setTags {
+		return errors.New(accountsUsage)
+	}
+	if *weight != 0 && (*weight < 1 || *weight > bulk.MaxWeight) {
+		return fmt.Errorf("Weight must be between 1 and %d", bulk.MaxWeight)
+	}
+	tagList := bulk.NormalizeTags(strings.Split(*tags, ","))
+	for _, tag := range tagList {
+		if err := config.ValidateTag(tag); err != nil {
+			return err
+		}
+	}
+
+	ids, err := parseIDs(fs.Args(), accountsUsage)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if *weight != 0 {
+			if err := database.SetAccountWeight(id, *weight); err != nil {
+				return fmt.Errorf("Failed to set weight of account %d: %w", id, err)
+			}
+		}
+		if setTags {
+			if err := database.SetAccountTags(id, tagList); err != nil {
+				return fmt.Errorf("Failed to set tags of account %d: %w", id, err)
+			}
+		}
+		fmt.Printf("Updated account %d\n", id)
+	}
+	return nil
+}
+
+func printAccounts(accounts []models.Account) {
+	rows := make([][]string, len(accounts))
+	for i, acc := range accounts {
+		owner := "-"
+		if acc.LinuxdoID != nil {
+			owner = strconv.Itoa(*acc.LinuxdoID)
+		}
+		rows[i] = []string{
+			strconv.Itoa(acc.ID),
+			acc.TeamID,
+			owner,
+			acc.Health(),
+			acc.Upstream,
+			strconv.Itoa(acc.Weight),
+			strings.Join(acc.Tags, ","),
+			strconv.Itoa(acc.RequestCount),
+			strconv.Itoa(acc.FailCount),
+			formatTime(acc.LastUsedAt),
+			truncate(acc.LastError, 40),
+		}
+	}
+	printTable([]string{"ID", "TEAM", "OWNER", "STATE", "UPSTREAM", "WEIGHT", "TAGS", "REQUESTS", "FAILS", "LAST USED", "LAST ERROR"}, rows)
+}
+
+func addAccountCmd(args []string) error {
+	fs := flag.NewFlagSet("accounts add", flag.ContinueOnError)
+	asJSON := outputFlag(fs)
+	var rec bulk.Record
+	fs.StringVar(&rec.Auth, "auth", "", `Cosine auth cookie, or "-" to read it from stdin`)
+	fs.StringVar(&rec.TeamID, "team", "", "Cosine team ID")
+	fs.StringVar(&rec.RefreshToken, "refresh-token", "", "refresh token renewing the session token")
+	fs.IntVar(&rec.Weight, "weight", 1, "share of requests relative to other accounts")
+	tags := fs.String("tags", "", "comma separated tags")
+	owner := fs.Int("owner", 0, "LinuxDo ID of the donor; omit for a pool account")
+	fs.StringVar(&rec.Upstream, "upstream", "", "pin the account to this upstream endpoint")
+	fs.StringVar(&rec.Proxy, "proxy", "", "egress proxy URL for this account")
+	noProbe := fs.Bool("no-probe", false, "add without checking the credential against Cosine")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if rec.Auth == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("Failed to read auth from stdin: %w", err)
+		}
+		rec.Auth = line
+	}
+	if *tags != "" {
+		rec.Tags = strings.Split(*tags, ",")
+	}
+	if *owner != 0 {
+		rec.LinuxdoID = owner
+	}
+
+	bulk.Normalize(&rec)
+	if err := bulk.Validate(&rec); err != nil {
+		return fmt.Errorf("Invalid account: %w", err)
+	}
+	if !*noProbe {
+		if _, err := bulk.Probe(context.Background(), &rec); err != nil {
+			return fmt.Errorf("Probe failed, account not added: %w", err)
+		}
+	}
+
+	acc, err := bulk.Create(&rec)
+	if err != nil {
+		return fmt.Errorf("Failed to add account: %w", err)
+	}
+	if *asJSON {
+		return printJSON(acc)
+	}
+	printAccounts([]models.Account{*acc})
+	return nil
+}
+
+// probeResult is one line of accounts probe output
+type probeResult struct {
+	ID        int    `json:"id"`
+	OK        bool   `json:"ok"`
+	Models    int    `json:"models"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func probeAccounts(args []string) error {
+	fs := flag.NewFlagSet("accounts probe", flag.ContinueOnError)
+	asJSON := outputFlag(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var accounts []models.Account
+	if fs.NArg() == 0 {
+		var err error
+		if accounts, err = database.GetActiveAccounts(); err != nil {
+			return fmt.Errorf("Failed to list accounts: %w", err)
+		}
+	} else {
+		ids, err := parseIDs(fs.Args(), accountsUsage)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			acc, err := database.GetAccountByID(id)
+			if err != nil {
+				return fmt.Errorf("Failed to load account %d: %w", id, err)
+			}
+			accounts = append(accounts, *acc)
+		}
+	}
+
+	results := make([]probeResult, len(accounts))
+	failed := 0
+	for i := range accounts {
+		n, latency, err := probeAccount(&accounts[i])
+		results[i] = probeResult{ID: accounts[i].ID, OK: err == nil, Models: n, LatencyMs: latency.Milliseconds()}
+		if err != nil {
+			results[i].Error = err.Error()
+			failed++
+		}
+	}
+
+	if *asJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		rows := make([][]string, len(results))
+		for i, r := range results {
+			status := "ok"
+			if !r.OK {
+				status = "fail"
+			}
+			rows[i] = []string{strconv.Itoa(r.ID), status, strconv.Itoa(r.Models), fmt.Sprintf("%dms", r.LatencyMs), r.Error}
+		}
+		printTable([]string{"ID", "STATUS", "MODELS", "LATENCY", "ERROR"}, rows)
+	}
+	if failed > 0 {
+		return errReported
+	}
+	return nil
+}
+
+func refreshAccounts(args []string) error {
+	ids, err := parseIDs(args, accountsUsage)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		acc, err := database.GetAccountByID(id)
+		if err != nil {
+			return fmt.Errorf("Failed to load account %d: %w", id, err)
+		}
+		if acc, err = refresh.Refresh(context.Background(), acc, refresh.TriggerManual); err != nil {
+			return fmt.Errorf("Failed to renew token of account %d: %w", id, err)
+		}
+		expires := "unknown expiry"
+		if exp, ok := refresh.ExpiresAt(acc.Auth); ok {
+			expires = "expires " + exp.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Printf("Renewed token of account %d, %s\n", id, expires)
+	}
+	return nil
+}
+
+// probeAccount checks the account's credential, timing the check
+func probeAccount(acc *models.Account) (int, time.Duration, error) {
+	start := time.Now()
+	n, err := bulk.ProbeAccount(context.Background(), acc)
+	return n, time.Since(start), err
+}
+
+func importAccounts(args []string) error {
+	fs := flag.NewFlagSet("accounts import", flag.ContinueOnError)
+	asJSON := outputFlag(fs)
+	format := fs.String("format", "", "csv or json; detected from the content when omitted")
+	var opts bulk.Options
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "validate without adding accounts")
+	noProbe := fs.Bool("no-probe", false, "skip checking every credential against Cosine")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase of an encrypted export")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	opts.Probe = !*noProbe
+
+	in := io.Reader(os.Stdin)
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("Failed to open %s: %w", path, err)
+		}
+		defer f.Close()
+		in = f
+	}
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("Failed to read accounts: %w", err)
+	}
+
+	passphrase := ""
+	if bulk.IsEncrypted(data) {
+		if passphrase, err = readPassphrase(*passphraseFile); err != nil {
+			return err
+		}
+	}
+	records, err := bulk.Decode(data, *format, passphrase)
+	if err != nil {
+		return fmt.Errorf("Failed to parse accounts: %w", err)
+	}
+
+	report, err := bulk.Import(context.Background(), records, opts)
+	if err != nil {
+		return fmt.Errorf("Import failed: %w", err)
+	}
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		rows := make([][]string, len(report.Rows))
+		for i, r := range report.Rows {
+			id := "-"
+			if r.AccountID != 0 {
+				id = strconv.Itoa(r.AccountID)
+			}
+			rows[i] = []string{strconv.Itoa(r.Row), r.TeamID, r.Status, id, r.Error}
+		}
+		printTable([]string{"ROW", "TEAM", "STATUS", "ACCOUNT", "ERROR"}, rows)
+		if report.DryRun {
+			fmt.Printf("\nDry run: %d valid, %d duplicate, %d invalid\n", report.Valid, report.Duplicates, report.Invalid)
+		} else {
+			fmt.Printf("\n%d created, %d duplicate, %d invalid, %d failed\n", report.Created, report.Duplicates, report.Invalid, report.Failed)
+		}
+	}
+	if report.Invalid > 0 || report.Failed > 0 {
+		return errReported
+	}
+	return nil
+}
+
+func exportAccounts(args []string) error {
+	fs := flag.NewFlagSet("accounts export", flag.ContinueOnError)
+	path := fs.String("o", "", "write to this file instead of stdout")
+	format := fs.String("format", bulk.FormatJSON, "csv or json; encrypted exports are always JSON")
+	encrypt := fs.Bool("encrypt", false, "encrypt the export with a passphrase")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	records, err := bulk.Export()
+	if err != nil {
+		return fmt.Errorf("Failed to list accounts: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if *encrypt {
+		*format = bulk.FormatJSON
+	}
+	if err := bulk.Encode(&buf, records, *format); err != nil {
+		return fmt.Errorf("Failed to encode accounts: %w", err)
+	}
+	data := buf.Bytes()
+	if *encrypt {
+		passphrase, err := readPassphrase(*passphraseFile)
+		if err != nil {
+			return err
+		}
+		if data, err = bulk.Encrypt(data, passphrase); err != nil {
+			return fmt.Errorf("Failed to encrypt accounts: %w", err)
+		}
+		data = append(data, '\n')
+	}
+
+	out := os.Stdout
+	if *path != "" {
+		// The export holds credentials, so only the owner may read it
+		f, err := os.OpenFile(*path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("Failed to create %s: %w", *path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("Failed to write accounts: %w", err)
+	}
+	if *encrypt {
+		log.Printf("Exported %d account(s), encrypted", len(records))
+	} else {
+		log.Printf("Exported %d account(s); the output contains credentials", len(records))
+	}
+	return nil
+}
+
+// readPassphrase reads the export passphrase from path, or from
+// $COSINE_EXPORT_PASSPHRASE when path is empty
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		passphrase := os.Getenv("COSINE_EXPORT_PASSPHRASE")
+		if passphrase == "" {
+			return "", errors.New("A passphrase is required: set COSINE_EXPORT_PASSPHRASE or use -passphrase-file")
+		}
+		return passphrase, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
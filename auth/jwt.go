@@ -14,21 +14,36 @@ type JWTClaims struct {
 	Name              string `json:"name"`
 	LinuxDoID         int    `json:"linuxdo_id"`
 	LinuxDoTrustLevel int    `json:"trust_level"`
+	// LoginAt is when the user last signed in through LinuxDo; refreshed
+	// tokens keep it so sessions can be capped
+	LoginAt *jwt.NumericDate `json:"login_at,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateToken generates a new JWT token for a LinuxDo user
-func GenerateToken(userID int64, username, name string, linuxDoID, trustLevel int, secret string) (string, error) {
+// tokenLifetime is how long an issued token stays valid at most
+const tokenLifetime = 7 * 24 * time.Hour
+
+// GenerateToken generates a new JWT token for a LinuxDo user who signed in at
+// loginAt. The token expires after tokenLifetime, or maxSessionAge after the
+// login if that comes first, so no token outlives its session.
+func GenerateToken(userID int64, username, name string, linuxDoID, trustLevel int, loginAt time.Time, maxSessionAge time.Duration, secret string) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(tokenLifetime)
+	if end := loginAt.Add(maxSessionAge); end.Before(expiresAt) {
+		expiresAt = end
+	}
+
 	claims := JWTClaims{
 		UserID:            userID,
 		Username:          username,
 		Name:              name,
 		LinuxDoID:         linuxDoID,
 		LinuxDoTrustLevel: trustLevel,
+		LoginAt:           jwt.NewNumericDate(loginAt),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * 7 * time.Hour)), // 7 days
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
@@ -55,3 +70,15 @@ func ParseToken(tokenString, secret string) (*JWTClaims, error) {
 
 	return nil, fmt.Errorf("invalid token")
 }
+
+// SessionStart returns when the session behind the token began: its login
+// time, or its issue time for tokens issued before login times were recorded
+func (c *JWTClaims) SessionStart() time.Time {
+	switch {
+	case c.LoginAt != nil:
+		return c.LoginAt.Time
+	case c.IssuedAt != nil:
+		return c.IssuedAt.Time
+	}
+	return time.Time{}
+}
@@ -1,18 +1,23 @@
 package auth
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 
 	"cosine/config"
+	"cosine/database"
+	"cosine/logging"
 
 	"github.com/gin-gonic/gin"
 )
 
-// AuthMiddleware validates JWT token and sets user claims in context
+// AuthMiddleware validates a JWT token or API key and sets user claims in context
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cfg := config.GlobalConfig
+		cfg := config.Get()
 		if cfg == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "config not loaded"})
 			c.Abort()
@@ -35,23 +40,146 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		claims, err := ParseToken(tokenString, cfg.JWT.Secret)
+		var claims *JWTClaims
+		var err error
+		if IsAPIKey(tokenString) {
+			claims, err = apiKeyClaims(c, tokenString)
+		} else {
+			claims, err = ParseToken(tokenString, cfg.JWT.Secret)
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token: " + err.Error()})
 			c.Abort()
 			return
 		}
 
+		// Banned users are refused even while their token is still valid
+		status, err := lookupUserStatus(claims.LinuxDoID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			c.Abort()
+			return
+		}
+		// Without the status a ban cannot be enforced, so refuse the request
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Error("Failed to load user status", "linuxdo_id", claims.LinuxDoID, "error", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to check user status"})
+			c.Abort()
+			return
+		}
+		if status.banned {
+			c.JSON(http.StatusForbidden, gin.H{"error": ErrUserBanned.Error()})
+			c.Abort()
+			return
+		}
+
 		// Set claims in context for use in handlers
 		c.Set("claims", claims)
 		c.Set("linuxdo_id", claims.LinuxDoID)
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("trust_level", claims.LinuxDoTrustLevel)
+		logging.With(c, "linuxdo_id", claims.LinuxDoID)
+
+		c.Next()
+	}
+}
+
+// PassthroughMiddleware serves requests carrying the caller's own Cosine
+// credential without authenticating them against our users when passthrough
+// is enabled; every other request goes through AuthMiddleware
+func PassthroughMiddleware() gin.HandlerFunc {
+	authenticate := AuthMiddleware()
+	return func(c *gin.Context) {
+		cfg := config.Get()
+		parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if cfg == nil || !cfg.Passthrough.Enabled || len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			authenticate(c)
+			return
+		}
+
+		cred, ok, err := ParsePassthrough(parts[1], c.GetHeader(TeamIDHeader))
+		if !ok {
+			authenticate(c)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		c.Set("passthrough", cred)
+		c.Set("passthrough_id", cred.Fingerprint())
+		logging.With(c, "passthrough_id", cred.Fingerprint())
 
 		c.Next()
 	}
 }
 
+// apiKeyClaims authenticates an API key and builds claims from its owner's
+// current record, so trust level changes apply without issuing a new key
+func apiKeyClaims(c *gin.Context, key string) (*JWTClaims, error) {
+	apiKey, err := database.GetAPIKeyByHash(HashAPIKey(key))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("unknown or revoked api key")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := database.GetLinuxDoUserByLinuxDoID(apiKey.LinuxDoID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckUser(config.Get(), user); err != nil {
+		return nil, err
+	}
+
+	if err := database.TouchAPIKey(apiKey.ID); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("Failed to update last use of api key", "api_key_id", apiKey.ID, "error", err)
+	}
+	c.Set("api_key_id", apiKey.ID)
+
+	return &JWTClaims{
+		UserID:            user.ID,
+		Username:          user.Username,
+		Name:              user.Name,
+		LinuxDoID:         user.LinuxDoID,
+		LinuxDoTrustLevel: user.TrustLevel,
+	}, nil
+}
+
+// AdminMiddleware allows only admins through. It must be used after AuthMiddleware.
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := GetClaimsFromContext(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !IsAdmin(config.Get(), claims.LinuxDoID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IsAdmin reports whether a user is an admin by config or by database flag
+func IsAdmin(cfg *config.Config, linuxDoID int) bool {
+	if slices.Contains(cfg.Admin.LinuxDoIDs, linuxDoID) {
+		return true
+	}
+
+	status, err := lookupUserStatus(linuxDoID)
+	return err == nil && status.isAdmin
+}
+
 // GetClaimsFromContext retrieves JWT claims from gin context
 func GetClaimsFromContext(c *gin.Context) (*JWTClaims, bool) {
 	claims, exists := c.Get("claims")
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PassthroughPrefix marks bearer tokens carrying the caller's own Cosine
+// credential with its team: cosine:<team_id>:<auth cookie>
+const PassthroughPrefix = "cosine:"
+
+// TeamIDHeader names the Cosine team of a bare Cosine session token
+const TeamIDHeader = "X-Cosine-Team-ID"
+
+// sessionIssuers are the issuers of Firebase session and ID tokens, which is
+// what Cosine auth cookies are
+var sessionIssuers = []string{"https://session.firebase.google.com/", "https://securetoken.google.com/"}
+
+// Credential is a Cosine credential supplied by the caller
+type Credential struct {
+	Auth   string
+	TeamID string
+}
+
+// Fingerprint identifies the credential in logs and rate limits without
+// revealing it
+func (c *Credential) Fingerprint() string {
+	sum := sha256.Sum256([]byte(c.Auth))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParsePassthrough recognizes a caller's own Cosine credential in a bearer
+// token: either cosine:<team_id>:<auth> or a bare Cosine session token with
+// the team in teamHeader. ok is false for other tokens, such as our JWTs and
+// API keys; err reports a recognized but unusable credential.
+func ParsePassthrough(token, teamHeader string) (cred *Credential, ok bool, err error) {
+	if rest, found := strings.CutPrefix(token, PassthroughPrefix); found {
+		teamID, cookie, _ := strings.Cut(rest, ":")
+		cred = &Credential{Auth: cookie, TeamID: teamID}
+	} else if isSessionToken(token) {
+		cred = &Credential{Auth: token, TeamID: strings.TrimSpace(teamHeader)}
+	} else {
+		return nil, false, nil
+	}
+
+	if cred.TeamID == "" {
+		return nil, true, errors.New("cosine team id is required, send it in the " + TeamIDHeader + " header or as cosine:<team_id>:<auth>")
+	}
+	if cred.Auth == "" || strings.ContainsAny(cred.Auth, ";, \t\r\n") || strings.ContainsAny(cred.TeamID, "\"\\ \t\r\n") {
+		return nil, true, errors.New("malformed cosine credential")
+	}
+	return cred, true, nil
+}
+
+// isSessionToken reports whether token is a Firebase-issued JWT. The
+// signature is not checked here; Cosine checks it.
+func isSessionToken(token string) bool {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return false
+	}
+	for _, issuer := range sessionIssuers {
+		if strings.HasPrefix(claims.Issuer, issuer) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPassthroughFromContext returns the caller's own Cosine credential when
+// the request is passed through instead of served from the pool
+func GetPassthroughFromContext(c *gin.Context) (*Credential, bool) {
+	cred, exists := c.Get("passthrough")
+	if !exists {
+		return nil, false
+	}
+	credential, ok := cred.(*Credential)
+	return credential, ok
+}
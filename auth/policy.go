@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+
+	"cosine/config"
+	"cosine/database"
+)
+
+var (
+	ErrUserBanned       = errors.New("user is banned")
+	ErrAccountInactive  = errors.New("linuxdo account is not active")
+	ErrAccountSilenced  = errors.New("linuxdo account is silenced")
+	ErrTrustLevelTooLow = errors.New("trust level is too low")
+	ErrModelNotAllowed  = errors.New("model is not allowed for your trust level")
+	ErrDonateNotAllowed = errors.New("donation is not allowed for your trust level")
+)
+
+// CheckAccountState decides whether a LinuxDo account may log in or refresh its token
+func CheckAccountState(cfg *config.Config, active, silenced bool, trustLevel int) error {
+	if !active {
+		return ErrAccountInactive
+	}
+	if silenced {
+		return ErrAccountSilenced
+	}
+	if trustLevel < cfg.Policy.MinTrustLevel {
+		return ErrTrustLevelTooLow
+	}
+	return nil
+}
+
+// CheckUser applies CheckAccountState to a stored user and also refuses banned users
+func CheckUser(cfg *config.Config, user *database.LinuxDoUser) error {
+	if user.Banned {
+		return ErrUserBanned
+	}
+	return CheckAccountState(cfg, user.Active, user.Silenced, user.TrustLevel)
+}
+
+// PolicyFor returns the policy of the highest configured level not above trustLevel.
+// It returns nil when no level applies, which means the user is unrestricted.
+func PolicyFor(cfg *config.Config, trustLevel int) *config.TrustLevelPolicy {
+	best := -1
+	for level := range cfg.Policy.TrustLevels {
+		if level <= trustLevel && level > best {
+			best = level
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	p := cfg.Policy.TrustLevels[best]
+	return &p
+}
+
+// CheckModel reports whether the trust level may use the given model
+func CheckModel(cfg *config.Config, trustLevel int, model string) error {
+	p := PolicyFor(cfg, trustLevel)
+	if p == nil || len(p.AllowedModels) == 0 {
+		return nil
+	}
+	for _, m := range p.AllowedModels {
+		if m == model {
+			return nil
+		}
+	}
+	return ErrModelNotAllowed
+}
+
+// CheckDonate reports whether the trust level may donate accounts
+func CheckDonate(cfg *config.Config, trustLevel int) error {
+	p := PolicyFor(cfg, trustLevel)
+	if p == nil || p.CanDonate {
+		return nil
+	}
+	return ErrDonateNotAllowed
+}
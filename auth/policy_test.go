@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"cosine/config"
+	"cosine/database"
+)
+
+func policyConfig() *config.Config {
+	return &config.Config{Policy: config.PolicyConfig{
+		MinTrustLevel: 1,
+		TrustLevels: map[int]config.TrustLevelPolicy{
+			1: {AllowedModels: []string{"gpt-5"}, DailyRequests: 50},
+			3: {CanDonate: true},
+		},
+	}}
+}
+
+func TestPolicyFor(t *testing.T) {
+	cfg := policyConfig()
+	tests := []struct {
+		trustLevel int
+		want       int // daily requests of the expected policy, -1 for none
+	}{
+		{trustLevel: 0, want: -1},
+		{trustLevel: 1, want: 50},
+		{trustLevel: 2, want: 50},
+		{trustLevel: 3, want: 0},
+		{trustLevel: 4, want: 0},
+	}
+	for _, tt := range tests {
+		p := PolicyFor(cfg, tt.trustLevel)
+		switch {
+		case tt.want < 0 && p != nil:
+			t.Errorf("PolicyFor(%d) = %+v, want nil", tt.trustLevel, p)
+		case tt.want >= 0 && (p == nil || p.DailyRequests != tt.want):
+			t.Errorf("PolicyFor(%d) = %+v, want daily_requests %d", tt.trustLevel, p, tt.want)
+		}
+	}
+
+	// The returned policy is a copy
+	PolicyFor(cfg, 1).AllowedModels = nil
+	if len(cfg.Policy.TrustLevels[1].AllowedModels) != 1 {
+		t.Fatal("PolicyFor returned the configured policy instead of a copy")
+	}
+}
+
+func TestCheckModel(t *testing.T) {
+	cfg := policyConfig()
+	tests := []struct {
+		trustLevel int
+		model      string
+		allowed    bool
+	}{
+		{trustLevel: 0, model: "claude", allowed: true},
+		{trustLevel: 1, model: "gpt-5", allowed: true},
+		{trustLevel: 1, model: "claude"},
+		{trustLevel: 2, model: "claude"},
+		{trustLevel: 3, model: "claude", allowed: true},
+	}
+	for _, tt := range tests {
+		err := CheckModel(cfg, tt.trustLevel, tt.model)
+		if tt.allowed && err != nil || !tt.allowed && !errors.Is(err, ErrModelNotAllowed) {
+			t.Errorf("CheckModel(%d, %q) = %v, want allowed %v", tt.trustLevel, tt.model, err, tt.allowed)
+		}
+	}
+}
+
+func TestCheckDonate(t *testing.T) {
+	cfg := policyConfig()
+	for level, want := range map[int]error{0: nil, 1: ErrDonateNotAllowed, 2: ErrDonateNotAllowed, 3: nil} {
+		if err := CheckDonate(cfg, level); !errors.Is(err, want) {
+			t.Errorf("CheckDonate(%d) = %v, want %v", level, err, want)
+		}
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	cfg := policyConfig()
+	tests := []struct {
+		name string
+		user database.LinuxDoUser
+		want error
+	}{
+		{name: "allowed", user: database.LinuxDoUser{Active: true, TrustLevel: 1}},
+		{name: "banned", user: database.LinuxDoUser{Active: true, TrustLevel: 3, Banned: true}, want: ErrUserBanned},
+		{name: "inactive", user: database.LinuxDoUser{TrustLevel: 3}, want: ErrAccountInactive},
+		{name: "silenced", user: database.LinuxDoUser{Active: true, Silenced: true, TrustLevel: 3}, want: ErrAccountSilenced},
+		{name: "trust level too low", user: database.LinuxDoUser{Active: true}, want: ErrTrustLevelTooLow},
+	}
+	for _, tt := range tests {
+		if err := CheckUser(cfg, &tt.user); !errors.Is(err, tt.want) {
+			t.Errorf("%s: CheckUser = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
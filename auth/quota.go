@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+	"cosine/ratelimit"
+)
+
+var (
+	ErrDailyRequestQuota = errors.New("daily request quota exceeded")
+	ErrDailyTokenQuota   = errors.New("daily token quota exceeded")
+)
+
+// dailyKeys returns the rate limit store counters holding a user's usage
+// today and when they expire. Keeping them in the shared store makes the
+// trust-level quotas hold across instances when the Postgres store is used.
+func dailyKeys(linuxDoID int) (requestsKey, tokensKey string, expiresAt time.Time) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	day := start.Format("2006-01-02")
+	return fmt.Sprintf("policy:requests:%d:%s", linuxDoID, day),
+		fmt.Sprintf("policy:tokens:%d:%s", linuxDoID, day),
+		start.AddDate(0, 0, 1)
+}
+
+// ReserveDailyRequest checks the user's daily quotas and counts one request against them.
+// Once a daily quota is used up, the request is paid with donation credits if the user has any.
+// The returned refund func gives the request back, for requests that failed on our side;
+// it is never nil, and does nothing when err is set since the request was not counted.
+func ReserveDailyRequest(cfg *config.Config, linuxDoID, trustLevel int) (refund func(), err error) {
+	refund = func() {}
+	p := PolicyFor(cfg, trustLevel)
+	if p == nil {
+		return refund, nil
+	}
+
+	ctx := context.Background()
+	store := ratelimit.Default()
+	requestsKey, tokensKey, expiresAt := dailyKeys(linuxDoID)
+
+	requests, err := store.Add(ctx, requestsKey, 1, expiresAt)
+	if err != nil {
+		slog.Error("Failed to count daily requests", "linuxdo_id", linuxDoID, "error", err)
+		return refund, nil
+	}
+	refund = func() {
+		if _, err := store.Add(ctx, requestsKey, -1, expiresAt); err != nil {
+			slog.Error("Failed to refund daily request", "linuxdo_id", linuxDoID, "error", err)
+		}
+	}
+	tokens, err := store.Get(ctx, tokensKey)
+	if err != nil {
+		slog.Error("Failed to load daily tokens", "linuxdo_id", linuxDoID, "error", err)
+	}
+
+	overRequests := p.DailyRequests > 0 && requests > int64(p.DailyRequests)
+	overTokens := p.DailyTokens > 0 && tokens >= int64(p.DailyTokens)
+	if !overRequests && !overTokens {
+		return refund, nil
+	}
+
+	if err := spendCredits(linuxDoID, overRequests, overTokens); err != nil {
+		refund()
+		return func() {}, err
+	}
+	if overRequests {
+		// The request was paid with a credit, which goes back too
+		uncount := refund
+		refund = func() {
+			uncount()
+			if err := database.RefundCredits(linuxDoID, 1, 0); err != nil {
+				slog.Error("Failed to refund request credit", "linuxdo_id", linuxDoID, "error", err)
+			}
+		}
+	}
+	return refund, nil
+}
+
+// spendCredits pays for one request beyond the daily quota. Tokens are only
+// checked here and deducted once the request finishes.
+func spendCredits(linuxDoID int, overRequests, overTokens bool) error {
+	if overTokens {
+		balance, err := database.GetCreditBalance(linuxDoID)
+		if err != nil || balance.Tokens <= 0 {
+			return ErrDailyTokenQuota
+		}
+	}
+
+	if overRequests {
+		used, err := database.ConsumeCredits(linuxDoID, 1, 0)
+		if err != nil || used.Requests < 1 {
+			return ErrDailyRequestQuota
+		}
+	}
+	return nil
+}
+
+// RecordDailyTokens adds the tokens consumed by a finished request to the user's
+// daily usage, deducting the part beyond the daily token quota from donation credits
+func RecordDailyTokens(cfg *config.Config, linuxDoID, trustLevel, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+
+	_, tokensKey, expiresAt := dailyKeys(linuxDoID)
+	total, err := ratelimit.Default().Add(context.Background(), tokensKey, int64(tokens), expiresAt)
+	if err != nil {
+		slog.Error("Failed to count daily tokens", "linuxdo_id", linuxDoID, "error", err)
+		return
+	}
+
+	p := PolicyFor(cfg, trustLevel)
+	if p == nil || p.DailyTokens <= 0 {
+		return
+	}
+
+	over := min(int64(tokens), total-int64(p.DailyTokens))
+	if over <= 0 {
+		return
+	}
+	if _, err := database.ConsumeCredits(linuxDoID, 0, over); err != nil {
+		slog.Error("Failed to deduct token credits", "linuxdo_id", linuxDoID, "tokens", over, "error", err)
+	}
+}
+
+// DailyUsage returns the requests and tokens a user has used today
+func DailyUsage(linuxDoID int) (requests, tokens int64) {
+	ctx := context.Background()
+	store := ratelimit.Default()
+	requestsKey, tokensKey, _ := dailyKeys(linuxDoID)
+
+	requests, _ = store.Get(ctx, requestsKey)
+	tokens, _ = store.Get(ctx, tokensKey)
+	return requests, tokens
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"cosine/database"
+)
+
+// statusTTL bounds how long a ban or admin change can take to apply to
+// tokens that were issued before it.
+const statusTTL = 30 * time.Second
+
+// userStatus caches the database flags that are checked on every request
+type userStatus struct {
+	banned    bool
+	isAdmin   bool
+	fetchedAt time.Time
+}
+
+var (
+	statusMu    sync.Mutex
+	statusCache = make(map[int]userStatus)
+	// lastSweep is when expired entries were last dropped from statusCache
+	lastSweep time.Time
+)
+
+// lookupUserStatus returns the cached status of a user, reloading it from the database when stale
+func lookupUserStatus(linuxDoID int) (userStatus, error) {
+	statusMu.Lock()
+	st, ok := statusCache[linuxDoID]
+	statusMu.Unlock()
+	if ok && time.Since(st.fetchedAt) < statusTTL {
+		return st, nil
+	}
+
+	user, err := database.GetLinuxDoUserByLinuxDoID(linuxDoID)
+	if err != nil {
+		return userStatus{}, err
+	}
+
+	st = userStatus{banned: user.Banned, isAdmin: user.IsAdmin, fetchedAt: time.Now()}
+	statusMu.Lock()
+	statusCache[linuxDoID] = st
+	sweepStatusCache(st.fetchedAt)
+	statusMu.Unlock()
+	return st, nil
+}
+
+// sweepStatusCache drops expired entries at most once per statusTTL, so the
+// cache holds only users seen recently. statusMu must be held.
+func sweepStatusCache(now time.Time) {
+	if now.Sub(lastSweep) < statusTTL {
+		return
+	}
+	lastSweep = now
+	for id, st := range statusCache {
+		if now.Sub(st.fetchedAt) >= statusTTL {
+			delete(statusCache, id)
+		}
+	}
+}
+
+// InvalidateUserStatus drops the cached status so the next request reloads it
+func InvalidateUserStatus(linuxDoID int) {
+	statusMu.Lock()
+	delete(statusCache, linuxDoID)
+	statusMu.Unlock()
+}
@@ -0,0 +1,277 @@
+// Package bulk imports and exports pool accounts as CSV, JSON or encrypted
+// JSON files, validating every row and optionally checking its credential
+// against Cosine. The CLI and the admin API share it.
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+	"cosine/models"
+	"cosine/upstream"
+)
+
+// MaxWeight bounds the weight of an account in the rotation
+const MaxWeight = 100
+
+// probeTimeout bounds one credential check
+const probeTimeout = 15 * time.Second
+
+// probeConcurrency is the number of credentials checked at once
+const probeConcurrency = 4
+
+// Record is one account in an import or export file. Unlike API responses
+// it carries the credentials and proxy. Zero Weight means 1.
+type Record struct {
+	Auth         string   `json:"auth"`
+	TeamID       string   `json:"team_id"`
+	RefreshToken string   `json:"refresh_token,omitempty"`
+	Weight       int      `json:"weight,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	LinuxdoID    *int     `json:"linuxdo_id,omitempty"`
+	Upstream     string   `json:"upstream,omitempty"`
+	Proxy        string   `json:"proxy,omitempty"`
+	Disabled     bool     `json:"disabled,omitempty"`
+}
+
+// Row statuses reported by Import
+const (
+	StatusCreated   = "created"   // the account was added
+	StatusValid     = "valid"     // dry run: the account would be added
+	StatusDuplicate = "duplicate" // the credential is already in the pool or earlier in the file
+	StatusInvalid   = "invalid"   // the row failed validation or the credential check
+	StatusFailed    = "failed"    // the row was valid but storing it failed
+)
+
+// Options controls Import
+type Options struct {
+	// DryRun validates without adding accounts
+	DryRun bool
+	// Probe checks every valid credential against Cosine
+	Probe bool
+}
+
+// RowResult is the outcome of one row; Row counts from 1
+type RowResult struct {
+	Row       int    `json:"row"`
+	TeamID    string `json:"team_id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	AccountID int    `json:"account_id,omitempty"`
+}
+
+// Report summarizes an import
+type Report struct {
+	DryRun     bool        `json:"dry_run"`
+	Created    int         `json:"created"`
+	Valid      int         `json:"valid"`
+	Duplicates int         `json:"duplicates"`
+	Invalid    int         `json:"invalid"`
+	Failed     int         `json:"failed"`
+	Rows       []RowResult `json:"rows"`
+}
+
+// Normalize applies defaults and canonical forms to rec: weight 1 when
+// unset, trimmed, lowercased and deduplicated tags
+func Normalize(rec *Record) {
+	rec.Auth = strings.TrimSpace(rec.Auth)
+	rec.RefreshToken = strings.TrimSpace(rec.RefreshToken)
+	rec.TeamID = strings.TrimSpace(rec.TeamID)
+	if rec.Weight == 0 {
+		rec.Weight = 1
+	}
+	rec.Tags = NormalizeTags(rec.Tags)
+}
+
+// NormalizeTags trims, lowercases and deduplicates tags, dropping empty ones
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// Validate checks a normalized record without contacting Cosine, reporting
+// every problem of the row in one error
+func Validate(rec *Record) error {
+	var problems []string
+	if rec.Auth == "" {
+		problems = append(problems, "auth is required")
+	}
+	if rec.TeamID == "" {
+		problems = append(problems, "team_id is required")
+	}
+	if rec.Weight < 1 || rec.Weight > MaxWeight {
+		problems = append(problems, fmt.Sprintf("weight must be between 1 and %d", MaxWeight))
+	}
+	for _, tag := range rec.Tags {
+		if err := config.ValidateTag(tag); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if rec.LinuxdoID != nil && *rec.LinuxdoID <= 0 {
+		problems = append(problems, "linuxdo_id must be positive")
+	}
+	if !upstream.Known(rec.Upstream) {
+		problems = append(problems, fmt.Sprintf("unknown upstream endpoint %q", rec.Upstream))
+	}
+	if err := config.ValidateProxyURL(rec.Proxy); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Probe checks the record's credential through the endpoint and proxy the
+// account would use, returning the number of models Cosine offers it
+func Probe(ctx context.Context, rec *Record) (int, error) {
+	return ProbeAccount(ctx, &models.Account{Auth: rec.Auth, TeamID: rec.TeamID, Upstream: rec.Upstream, Proxy: rec.Proxy})
+}
+
+// ProbeAccount checks an account's credential by listing its Cosine models
+func ProbeAccount(ctx context.Context, acc *models.Account) (int, error) {
+	client, err := upstream.NewCosineClient(acc)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	names, err := client.ListModels(ctx, acc.Auth)
+	return len(names), err
+}
+
+// Create adds the account described by a validated record
+func Create(rec *Record) (*models.Account, error) {
+	acc := &models.Account{
+		Auth:         rec.Auth,
+		TeamID:       rec.TeamID,
+		LinuxdoID:    rec.LinuxdoID,
+		IsActive:     !rec.Disabled,
+		RefreshToken: rec.RefreshToken,
+		Weight:       rec.Weight,
+		Tags:         rec.Tags,
+		Upstream:     rec.Upstream,
+		Proxy:        rec.Proxy,
+	}
+	if rec.Disabled {
+		acc.DisabledBy = models.DisabledByAdmin
+	}
+	return database.InsertAccount(acc)
+}
+
+// Import validates every record and adds the valid ones that are not in the
+// pool yet. Invalid rows do not stop the others.
+func Import(ctx context.Context, records []Record, opts Options) (*Report, error) {
+	existing, err := database.ListAccounts(nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(existing)+len(records))
+	for _, acc := range existing {
+		seen[acc.Auth] = true
+	}
+
+	report := &Report{DryRun: opts.DryRun, Rows: make([]RowResult, len(records))}
+	for i := range records {
+		rec := &records[i]
+		Normalize(rec)
+		row := &report.Rows[i]
+		*row = RowResult{Row: i + 1, TeamID: rec.TeamID, Status: StatusValid}
+		if err := Validate(rec); err != nil {
+			row.Status, row.Error = StatusInvalid, err.Error()
+			continue
+		}
+		if seen[rec.Auth] {
+			row.Status = StatusDuplicate
+			continue
+		}
+		seen[rec.Auth] = true
+	}
+
+	if opts.Probe {
+		probeRows(ctx, records, report.Rows)
+	}
+
+	for i := range records {
+		row := &report.Rows[i]
+		if row.Status == StatusValid && !opts.DryRun {
+			acc, err := Create(&records[i])
+			if err != nil {
+				row.Status, row.Error = StatusFailed, err.Error()
+			} else {
+				row.Status, row.AccountID = StatusCreated, acc.ID
+			}
+		}
+
+		switch row.Status {
+		case StatusCreated:
+			report.Created++
+		case StatusValid:
+			report.Valid++
+		case StatusDuplicate:
+			report.Duplicates++
+		case StatusInvalid:
+			report.Invalid++
+		case StatusFailed:
+			report.Failed++
+		}
+	}
+	return report, nil
+}
+
+// probeRows checks the credentials of the rows still valid, marking the
+// ones Cosine rejects invalid
+func probeRows(ctx context.Context, records []Record, rows []RowResult) {
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for i := range rows {
+		if rows[i].Status != StatusValid {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := Probe(ctx, &records[i]); err != nil {
+				rows[i].Status, rows[i].Error = StatusInvalid, "credential check failed: "+err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Export returns every account, including disabled ones, as records
+func Export() ([]Record, error) {
+	accounts, err := database.ListAccounts(nil)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, len(accounts))
+	for i, acc := range accounts {
+		records[i] = Record{
+			Auth:         acc.Auth,
+			TeamID:       acc.TeamID,
+			RefreshToken: acc.RefreshToken,
+			Weight:       acc.Weight,
+			Tags:         acc.Tags,
+			LinuxdoID:    acc.LinuxdoID,
+			Upstream:     acc.Upstream,
+			Proxy:        acc.Proxy,
+			Disabled:     !acc.IsActive,
+		}
+	}
+	return records, nil
+}
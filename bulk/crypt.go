@@ -0,0 +1,101 @@
+package bulk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// encryptedVersion identifies the encrypted export format
+const encryptedVersion = 1
+
+// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+const pbkdf2Iterations = 600000
+
+// MinPassphraseLength is the shortest passphrase Encrypt accepts
+const MinPassphraseLength = 12
+
+// envelope is an encrypted export: the JSON records sealed with AES-256-GCM
+// under a key derived from the passphrase with PBKDF2-HMAC-SHA256
+type envelope struct {
+	Version    int    `json:"cosine_accounts_export"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypt seals plaintext with a key derived from passphrase
+func Encrypt(plaintext []byte, passphrase string) ([]byte, error) {
+	if len(passphrase) < MinPassphraseLength {
+		return nil, fmt.Errorf("passphrase must be at least %d characters", MinPassphraseLength)
+	}
+
+	env := envelope{
+		Version:    encryptedVersion,
+		KDF:        "pbkdf2-sha256",
+		Iterations: pbkdf2Iterations,
+		Salt:       make([]byte, 16),
+	}
+	rand.Read(env.Salt)
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	rand.Read(env.Nonce)
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, nil)
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// Decrypt opens an export sealed by Encrypt
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid encrypted export: %w", err)
+	}
+	if env.Version != encryptedVersion || env.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("unsupported encrypted export version %d (%s)", env.Version, env.KDF)
+	}
+	if env.Iterations < 1 || env.Iterations > 10*pbkdf2Iterations {
+		return nil, fmt.Errorf("invalid encrypted export: %d iterations", env.Iterations)
+	}
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid encrypted export: bad nonce")
+	}
+	plain, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted export")
+	}
+	return plain, nil
+}
+
+// IsEncrypted reports whether data is an export sealed by Encrypt
+func IsEncrypted(data []byte) bool {
+	var probe struct {
+		Version int `json:"cosine_accounts_export"`
+	}
+	return json.Unmarshal(data, &probe) == nil && probe.Version != 0
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
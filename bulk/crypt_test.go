@@ -0,0 +1,80 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const testPassphrase = "correct horse battery"
+
+func TestEncryptDecrypt(t *testing.T) {
+	plain := []byte(`[{"auth":"cookie","team_id":"t1"}]`)
+	sealed, err := Encrypt(plain, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("cookie")) {
+		t.Fatal("encrypted export contains the plaintext")
+	}
+	if !IsEncrypted(sealed) || IsEncrypted(plain) {
+		t.Fatal("IsEncrypted does not tell encrypted exports apart")
+	}
+
+	got, err := Decrypt(sealed, testPassphrase)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Decrypt = %q, %v", got, err)
+	}
+	if _, err := Decrypt(sealed, testPassphrase+"!"); err == nil {
+		t.Fatal("Decrypt accepted a wrong passphrase")
+	}
+
+	var env envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+	env.Ciphertext[0] ^= 1
+	tampered, _ := json.Marshal(env)
+	if _, err := Decrypt(tampered, testPassphrase); err == nil {
+		t.Fatal("Decrypt accepted a tampered export")
+	}
+
+	env.Ciphertext[0] ^= 1
+	env.Iterations = 1 << 30
+	costly, _ := json.Marshal(env)
+	if _, err := Decrypt(costly, testPassphrase); err == nil {
+		t.Fatal("Decrypt accepted an unbounded iteration count")
+	}
+
+	if _, err := Encrypt(plain, "short"); err == nil {
+		t.Fatal("Encrypt accepted a short passphrase")
+	}
+}
+
+func TestDecodeEncrypted(t *testing.T) {
+	owner := 42
+	records := []Record{
+		{Auth: "a1", TeamID: "t1", Weight: 2, Tags: []string{"x", "y"}, LinuxdoID: &owner, RefreshToken: "r1"},
+		{Auth: "a2", TeamID: "t2", Weight: 1, Proxy: "http://proxy:8080", Disabled: true},
+	}
+	var buf bytes.Buffer
+	if err := Encode(&buf, records, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Encrypt(buf.Bytes(), testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(sealed, "", ""); err == nil {
+		t.Fatal("Decode read an encrypted export without a passphrase")
+	}
+	got, err := Decode(sealed, FormatCSV, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records) {
+		t.Fatalf("Decode = %+v, want %+v", got, records)
+	}
+}
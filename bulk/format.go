@@ -0,0 +1,165 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Supported file formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// csvColumns are the CSV header names, in the order Encode writes them.
+// auth and team_id are required when decoding; the others may be omitted.
+var csvColumns = []string{"auth", "team_id", "weight", "tags", "linuxdo_id", "upstream", "proxy", "disabled", "refresh_token"}
+
+// Decode parses an import file. An encrypted export is decrypted with
+// passphrase first. An empty format is detected from the content: JSON when
+// it starts with "[", CSV otherwise.
+func Decode(data []byte, format, passphrase string) ([]Record, error) {
+	if IsEncrypted(data) {
+		if passphrase == "" {
+			return nil, errors.New("the file is encrypted, a passphrase is required")
+		}
+		plain, err := Decrypt(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		data, format = plain, FormatJSON
+	}
+
+	if format == "" {
+		format = FormatCSV
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			format = FormatJSON
+		}
+	}
+	switch format {
+	case FormatJSON:
+		var records []Record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return records, nil
+	case FormatCSV:
+		return decodeCSV(data)
+	default:
+		return nil, fmt.Errorf("unsupported format %q, use json or csv", format)
+	}
+}
+
+func decodeCSV(data []byte) ([]Record, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty CSV file")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		index[name] = i
+	}
+	for _, required := range []string{"auth", "team_id"} {
+		if _, ok := index[required]; !ok {
+			return nil, fmt.Errorf("CSV header lacks the %s column", required)
+		}
+	}
+
+	var records []Record
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := index[name]; ok {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		rec := Record{
+			Auth:         field("auth"),
+			TeamID:       field("team_id"),
+			Upstream:     field("upstream"),
+			Proxy:        field("proxy"),
+			RefreshToken: field("refresh_token"),
+		}
+		if v := field("weight"); v != "" {
+			if rec.Weight, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid weight %q", line, v)
+			}
+		}
+		if v := field("tags"); v != "" {
+			rec.Tags = strings.Split(v, ";")
+		}
+		if v := field("linuxdo_id"); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid linuxdo_id %q", line, v)
+			}
+			rec.LinuxdoID = &id
+		}
+		if v := field("disabled"); v != "" {
+			if rec.Disabled, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("line %d: invalid disabled %q", line, v)
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// Encode writes records as JSON or CSV. CSV tags are separated by ";".
+func Encode(w io.Writer, records []Record, format string) error {
+	switch format {
+	case "", FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(csvColumns)
+		for _, rec := range records {
+			linuxdoID := ""
+			if rec.LinuxdoID != nil {
+				linuxdoID = strconv.Itoa(*rec.LinuxdoID)
+			}
+			cw.Write([]string{
+				rec.Auth,
+				rec.TeamID,
+				strconv.Itoa(rec.Weight),
+				strings.Join(rec.Tags, ";"),
+				linuxdoID,
+				rec.Upstream,
+				rec.Proxy,
+				strconv.FormatBool(rec.Disabled),
+				rec.RefreshToken,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unsupported format %q, use json or csv", format)
+	}
+}
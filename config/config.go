@@ -2,16 +2,149 @@ package config
 
 import (
 	"os"
+	"slices"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Upstream UpstreamConfig `yaml:"upstream"`
-	LinuxDo  LinuxDoConfig  `yaml:"linuxdo"`
-	JWT      JWTConfig      `yaml:"jwt"`
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Upstream  UpstreamConfig  `yaml:"upstream"`
+	LinuxDo   LinuxDoConfig   `yaml:"linuxdo"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Policy    PolicyConfig    `yaml:"policy"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Rewards   RewardsConfig   `yaml:"rewards"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Models    []ModelConfig   `yaml:"models"`
+	Routing   RoutingConfig   `yaml:"routing"`
+
+	Passthrough  PassthroughConfig  `yaml:"passthrough"`
+	TokenRefresh TokenRefreshConfig `yaml:"token_refresh"`
+
+	ModelDiscovery ModelDiscoveryConfig `yaml:"model_discovery"`
+	Metrics        MetricsConfig        `yaml:"metrics"`
+	Tracing        TracingConfig        `yaml:"tracing"`
+	Logging        LoggingConfig        `yaml:"logging"`
+	Health         HealthConfig         `yaml:"health"`
+}
+
+// HealthConfig sets when /readyz reports the instance ready: the database
+// answers a ping within Timeout, at least MinAccounts accounts are usable and,
+// once MinRequests upstream requests were made within Window, at least
+// MinSuccessRate of them succeeded.
+type HealthConfig struct {
+	Timeout        time.Duration `yaml:"timeout"`
+	MinAccounts    int           `yaml:"min_accounts"`
+	Window         time.Duration `yaml:"window"`
+	MinRequests    int           `yaml:"min_requests"`
+	MinSuccessRate float64       `yaml:"min_success_rate"`
+}
+
+// LoggingConfig controls the log output. Level is debug, info, warn or error
+// and follows config reloads; Format is json or text.
+type LoggingConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// TracingConfig controls OpenTelemetry tracing. Exporter is "none", "stdout"
+// or "otlp"; OTLP traces go over HTTP to Endpoint (host:port), defaulting to
+// the standard OTEL_EXPORTER_OTLP_* environment variables. SampleRatio is the
+// share of new traces recorded; traces started by a client follow its decision.
+type TracingConfig struct {
+	Exporter    string            `yaml:"exporter"`
+	Endpoint    string            `yaml:"endpoint"`
+	Insecure    bool              `yaml:"insecure"`
+	Headers     map[string]string `yaml:"headers"`
+	ServiceName string            `yaml:"service_name"`
+	SampleRatio float64           `yaml:"sample_ratio"`
+}
+
+// MetricsConfig controls the Prometheus endpoint at /metrics, off by default
+// since the metrics name pool accounts and endpoints. A non-empty Token must
+// be sent by scrapers as a bearer token.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Token   string `yaml:"token"`
+}
+
+// ModelConfig declares a model served through /v1. ID is the name clients
+// use and Upstream the Cosine model it maps to, defaulting to ID. Aliases
+// are other names resolving to this model. Vision, Tools and ContextLength
+// are advertised to clients; zero ContextLength means unknown.
+type ModelConfig struct {
+	ID            string   `yaml:"id"`
+	Upstream      string   `yaml:"upstream"`
+	Aliases       []string `yaml:"aliases"`
+	Disabled      bool     `yaml:"disabled"`
+	Vision        bool     `yaml:"vision"`
+	Tools         bool     `yaml:"tools"`
+	ContextLength int      `yaml:"context_length"`
+}
+
+// RoutingConfig maps requests to groups of accounts, named by account tags.
+// Accounts carrying one of ReservedTags serve only requests routed to that
+// tag; the others form the shared pool. Rules are tried in order and the
+// first match wins; requests matching no rule use the shared pool.
+type RoutingConfig struct {
+	ReservedTags []string      `yaml:"reserved_tags"`
+	Rules        []RoutingRule `yaml:"rules"`
+}
+
+// RoutingRule routes the requests matching all of its non-empty selectors
+// (LinuxDo users, API key IDs, model IDs) to the accounts carrying any of
+// Tags, or to the shared pool when Tags is empty. With Fallback, requests
+// use the shared pool while none of those accounts is available.
+type RoutingRule struct {
+	Name     string   `yaml:"name"`
+	Users    []int    `yaml:"users"`
+	APIKeys  []int    `yaml:"api_keys"`
+	Models   []string `yaml:"models"`
+	Tags     []string `yaml:"tags"`
+	Fallback bool     `yaml:"fallback"`
+}
+
+// PassthroughConfig lets clients use their own Cosine credential: when
+// Enabled, a chat request whose bearer token is cosine:<team_id>:<auth>, or a
+// Cosine session token with the team in the X-Cosine-Team-ID header, is sent
+// to Cosine with that credential instead of a pool account. Such requests
+// skip our users, quotas and usage records but are rate limited per
+// credential, and per client IP by PerIP so rotating credentials does not
+// lift the limits; pool access still requires our JWTs or API keys.
+type PassthroughConfig struct {
+	Enabled bool              `yaml:"enabled"`
+	PerIP   PassthroughLimits `yaml:"per_ip"`
+}
+
+// PassthroughLimits are the limits applied to the passthrough requests of one
+// client IP; zero disables a limit. Burst defaults to RequestsPerMinute and
+// MaxConcurrentStreams only counts streaming requests.
+type PassthroughLimits struct {
+	RequestsPerMinute    int `yaml:"requests_per_minute"`
+	Burst                int `yaml:"burst"`
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+}
+
+// TokenRefreshConfig renews the session tokens of accounts that store a
+// refresh token, through the Firebase secure token endpoint TokenURL with
+// Cosine's web APIKey. Every Interval, tokens expiring within Before are
+// renewed; a token Cosine rejects is renewed once before the account is
+// disabled.
+type TokenRefreshConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	APIKey   string        `yaml:"api_key"`
+	TokenURL string        `yaml:"token_url"`
+	Interval time.Duration `yaml:"interval"`
+	Before   time.Duration `yaml:"before"`
+}
+
+// AdminConfig lists LinuxDo users that are always admins, in addition to
+// users flagged with is_admin in the database.
+type AdminConfig struct {
+	LinuxDoIDs []int `yaml:"linuxdo_ids"`
 }
 
 type LinuxDoConfig struct {
@@ -20,40 +153,295 @@ type LinuxDoConfig struct {
 	BackendBaseURL string `yaml:"backend_base_url"`
 }
 
+// JWTConfig signs the tokens issued at login. Refreshing a token only
+// re-reads the user status stored at login, so tokens expire MaxSessionAge
+// after the login and users must sign in through LinuxDo again, which
+// fetches their current status. MaxSessionAge bounds how long a change on
+// LinuxDo goes unnoticed.
 type JWTConfig struct {
-	Secret string `yaml:"secret"`
+	Secret        string        `yaml:"secret"`
+	MaxSessionAge time.Duration `yaml:"max_session_age"`
+}
+
+// PolicyConfig controls who may log in and what each LinuxDo trust level
+// is allowed to do. Inactive and silenced accounts are always refused.
+type PolicyConfig struct {
+	MinTrustLevel int                      `yaml:"min_trust_level"`
+	TrustLevels   map[int]TrustLevelPolicy `yaml:"trust_levels"`
+}
+
+// TrustLevelPolicy describes the permissions granted to a trust level.
+// Empty AllowedModels allows every model; zero quotas mean unlimited.
+type TrustLevelPolicy struct {
+	AllowedModels []string `yaml:"allowed_models" json:"allowed_models"`
+	DailyRequests int      `yaml:"daily_requests" json:"daily_requests"`
+	DailyTokens   int      `yaml:"daily_tokens" json:"daily_tokens"`
+	CanDonate     bool     `yaml:"can_donate" json:"can_donate"`
+}
+
+// ModelDiscoveryConfig controls the job that asks Cosine which models each
+// pool account can use. Discovered models missing from Models are served
+// under their Cosine name, and requests only go to accounts offering the model.
+type ModelDiscoveryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RewardsConfig controls the quota credits donors earn for each hour one of
+// their accounts stays healthy. Credits are spent once the daily quota of the
+// donor's trust level is used up.
+type RewardsConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Interval        time.Duration `yaml:"interval"`
+	RequestsPerHour float64       `yaml:"requests_per_hour"`
+	TokensPerHour   float64       `yaml:"tokens_per_hour"`
 }
 
+// RateLimitConfig limits how much each user, or each API key when KeyBy is
+// "api_key", can consume through the chat endpoints. Store is "memory" for a
+// single instance or "postgres" to share limits between instances. Its token
+// quotas are hard caps counted apart from the policy daily_tokens, which
+// donation credits can extend; a request must pass both.
+type RateLimitConfig struct {
+	Enabled     bool               `yaml:"enabled"`
+	Store       string             `yaml:"store"`
+	KeyBy       string             `yaml:"key_by"`
+	Default     RateLimits         `yaml:"default"`
+	TrustLevels map[int]RateLimits `yaml:"trust_levels"`
+}
+
+// RateLimits are the limits applied to one caller; zero disables a limit.
+// Burst defaults to RequestsPerMinute. MaxConcurrentStreams only counts
+// streaming requests.
+type RateLimits struct {
+	RequestsPerMinute    int   `yaml:"requests_per_minute"`
+	Burst                int   `yaml:"burst"`
+	MaxConcurrentStreams int   `yaml:"max_concurrent_streams"`
+	DailyTokens          int64 `yaml:"daily_tokens"`
+	MonthlyTokens        int64 `yaml:"monthly_tokens"`
+}
+
+// ServerConfig controls the HTTP server. On SIGTERM or SIGINT /readyz fails
+// for DrainDelay while new requests are still accepted, then the listener
+// closes and in-flight requests get up to ShutdownTimeout to finish.
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	Port            int           `yaml:"port"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay      time.Duration `yaml:"drain_delay"`
 }
 
+// DatabaseConfig selects the storage backend. Driver is postgres (default)
+// or sqlite; Path is the database file used by sqlite.
+//
+// For Postgres, DSN takes a full connection string, either a
+// postgres:// URL or key=value pairs, and overrides the individual fields,
+// so options such as sslrootcert can be set. ConnectTimeout bounds how long
+// startup keeps retrying an unreachable database; zero means one minute.
 type DatabaseConfig struct {
+	Driver   string `yaml:"driver"`
+	Path     string `yaml:"path"`
+	DSN      string `yaml:"dsn"`
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	User     string `yaml:"user"`
 	Password string `yaml:"password"`
 	DBName   string `yaml:"dbname"`
 	SSLMode  string `yaml:"sslmode"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	ConnectTimeout  time.Duration `yaml:"connect_timeout"`
 }
 
+// UpstreamConfig lists the Cosine endpoints. BaseURL, when set, is the
+// endpoint named "default" and comes before Endpoints. Accounts not pinned to
+// an endpoint use the first healthy one in that order.
 type UpstreamConfig struct {
-	BaseURL string `yaml:"base_url"`
+	BaseURL   string                   `yaml:"base_url"`
+	Endpoints []UpstreamEndpointConfig `yaml:"endpoints"`
+	Transport TransportConfig          `yaml:"transport"`
+}
+
+// TransportConfig tunes the HTTP connections to every endpoint. Zero
+// durations and sizes mean no limit. IdleTimeout aborts a response, such as
+// a stream, that sends nothing for that long. Proxy is the default egress
+// proxy, overridden by an endpoint's or an account's own. UserAgent and
+// Headers are added to every upstream request.
+type TransportConfig struct {
+	DialTimeout           time.Duration     `yaml:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration     `yaml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration     `yaml:"response_header_timeout"`
+	IdleTimeout           time.Duration     `yaml:"idle_timeout"`
+	IdleConnTimeout       time.Duration     `yaml:"idle_conn_timeout"`
+	MaxIdleConns          int               `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int               `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int               `yaml:"max_conns_per_host"`
+	Proxy                 string            `yaml:"proxy"`
+	UserAgent             string            `yaml:"user_agent"`
+	Headers               map[string]string `yaml:"headers"`
+}
+
+// DefaultUpstream names the endpoint configured by upstream.base_url
+const DefaultUpstream = "default"
+
+// UpstreamEndpointConfig is one Cosine-compatible backend. Timeout overrides
+// transport.response_header_timeout for it. Proxy is an http, https or socks5
+// URL overriding transport.proxy; with neither set the proxy environment
+// variables apply.
+type UpstreamEndpointConfig struct {
+	Name    string            `yaml:"name"`
+	BaseURL string            `yaml:"base_url"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Proxy   string            `yaml:"proxy"`
+	TLS     UpstreamTLSConfig `yaml:"tls"`
+}
+
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
 }
 
-var GlobalConfig *Config
+// AllEndpoints returns the endpoints in selection order, base_url first
+func (u *UpstreamConfig) AllEndpoints() []UpstreamEndpointConfig {
+	if u.BaseURL == "" {
+		return u.Endpoints
+	}
+	return append([]UpstreamEndpointConfig{{Name: DefaultUpstream, BaseURL: u.BaseURL}}, u.Endpoints...)
+}
+
+// Defaults returns the configuration used for fields that neither the file
+// nor the environment set
+func Defaults() Config {
+	return Config{
+		Server: ServerConfig{Port: 7643, ShutdownTimeout: time.Minute},
+		JWT:    JWTConfig{MaxSessionAge: 12 * time.Hour},
+		Database: DatabaseConfig{
+			Driver:  "postgres",
+			Host:    "localhost",
+			Port:    5432,
+			User:    "cosine",
+			DBName:  "cosine2api",
+			SSLMode: "disable",
+		},
+		Upstream: UpstreamConfig{
+			BaseURL: "https://api.cosine.sh",
+			Transport: TransportConfig{
+				DialTimeout:           10 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ResponseHeaderTimeout: 2 * time.Minute,
+				IdleTimeout:           2 * time.Minute,
+				IdleConnTimeout:       90 * time.Second,
+				MaxIdleConns:          100,
+				MaxIdleConnsPerHost:   20,
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Store: "memory",
+			KeyBy: "user",
+		},
+		Passthrough: PassthroughConfig{
+			PerIP: PassthroughLimits{RequestsPerMinute: 60, MaxConcurrentStreams: 5},
+		},
+		TokenRefresh: TokenRefreshConfig{
+			TokenURL: "https://securetoken.googleapis.com/v1/token",
+			Interval: time.Minute,
+			Before:   10 * time.Minute,
+		},
+		Logging: LoggingConfig{Level: "info", Format: "json"},
+		Health: HealthConfig{
+			Timeout:        2 * time.Second,
+			MinAccounts:    1,
+			Window:         5 * time.Minute,
+			MinRequests:    10,
+			MinSuccessRate: 0.5,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "cosine2api",
+			SampleRatio: 1,
+		},
+		Models: []ModelConfig{
+			{ID: "gpt-5", Aliases: []string{"gpt-4o", "gpt-4"}, Vision: true, Tools: true, ContextLength: 400000},
+			{ID: "gpt4.1", Aliases: []string{"gpt-4.1"}, Vision: true, Tools: true, ContextLength: 1047576},
+			{ID: "claude-3-7-sonnet", Vision: true, Tools: true, ContextLength: 200000},
+			{ID: "gemini-2.0-flash", Vision: true, Tools: true, ContextLength: 1048576},
+		},
+	}
+}
 
+// Load builds the configuration from the defaults, the YAML file at path and
+// COSINE_* environment variables, in increasing precedence, validates it and
+// makes it the current snapshot returned by Get. An empty path skips the file.
 func Load(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
+	cfg, err := parse(path)
 	if err != nil {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+	reloadMu.Lock()
+	loadedPath = path
+	current.Store(cfg)
+	reloadMu.Unlock()
+	return cfg, nil
+}
+
+func parse(path string) (*Config, error) {
+	cfg := Defaults()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	GlobalConfig = &cfg
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.canonicalizeModels()
 	return &cfg, nil
 }
+
+// canonicalizeModels replaces model aliases in the model lists of the policy
+// and routing rules with the model IDs, which is what requests are checked
+// against once their model name is resolved
+func (c *Config) canonicalizeModels() {
+	ids := make(map[string]string)
+	for _, m := range c.Models {
+		for _, alias := range m.Aliases {
+			ids[alias] = m.ID
+		}
+	}
+	canonical := func(names []string) []string {
+		if len(names) == 0 {
+			return names
+		}
+		out := make([]string, 0, len(names))
+		for _, name := range names {
+			if id, ok := ids[name]; ok {
+				name = id
+			}
+			if !slices.Contains(out, name) {
+				out = append(out, name)
+			}
+		}
+		return out
+	}
+
+	for level, p := range c.Policy.TrustLevels {
+		p.AllowedModels = canonical(p.AllowedModels)
+		c.Policy.TrustLevels[level] = p
+	}
+	for i := range c.Routing.Rules {
+		c.Routing.Rules[i].Models = canonical(c.Routing.Rules[i].Models)
+	}
+}
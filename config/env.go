@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables overriding config fields
+const EnvPrefix = "COSINE_"
+
+// applyEnv overrides config fields from the environment. Each field is named
+// after its YAML path, e.g. database.password is COSINE_DATABASE_PASSWORD.
+// A variable ending in _FILE instead names a file holding the value, for
+// Docker secrets. Lists accept comma-separated values; maps and other
+// structured fields take a YAML value.
+func applyEnv(cfg *Config) error {
+	return applyEnvStruct(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"))
+}
+
+func applyEnvStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		field := v.Field(i)
+
+		// Nested sections recurse so each of their fields has its own variable
+		if field.Kind() == reflect.Struct {
+			if err := applyEnvStruct(field, name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// lookupEnv returns the value of name, or the contents of the file named by
+// name_FILE with trailing newlines removed. Setting both is an error.
+func lookupEnv(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	file, fileOK := os.LookupEnv(name + "_FILE")
+	if !fileOK {
+		return value, ok, nil
+	}
+	if ok {
+		return "", false, fmt.Errorf("both %s and %s_FILE are set", name, name)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Slice:
+		if !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			value = "[" + value + "]"
+		}
+	}
+
+	// The remaining kinds decode like the YAML file would, which also parses
+	// durations such as 30s
+	fresh := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), fresh.Interface()); err != nil {
+		return err
+	}
+	field.Set(fresh.Elem())
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// PeriodicJob is a background job driven by one config section T, which
+// reloads can enable, disable or retune
+type PeriodicJob[T comparable] struct {
+	// Name appears in the logs when the job is enabled or disabled
+	Name string
+	// Select picks the job's settings out of a config
+	Select func(*Config) T
+	// Schedule reports whether the settings enable the job and how often it runs
+	Schedule func(T) (enabled bool, interval time.Duration)
+	// Run performs one pass with the current settings
+	Run func(ctx context.Context, settings T)
+	// RunOnEnable runs a pass as soon as the job is enabled instead of
+	// waiting for the first tick
+	RunOnEnable bool
+	// OnDisable, if set, is called when a reload disables the job
+	OnDisable func()
+}
+
+// RunPeriodic runs job with the initial settings until ctx is done, following
+// config reloads that change its section. It does nothing while disabled.
+func RunPeriodic[T comparable](ctx context.Context, initial T, job PeriodicJob[T]) {
+	changed := make(chan T, 1)
+	Subscribe(func(old, new *Config) {
+		next := job.Select(new)
+		if job.Select(old) == next {
+			return
+		}
+		// Only the latest settings matter
+		select {
+		case <-changed:
+		default:
+		}
+		changed <- next
+	})
+
+	go job.loop(ctx, initial, changed)
+}
+
+func (job *PeriodicJob[T]) loop(ctx context.Context, settings T, changed <-chan T) {
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	apply := func() {
+		enabled, interval := job.Schedule(settings)
+		if ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+			if !enabled {
+				if job.OnDisable != nil {
+					job.OnDisable()
+				}
+				slog.Info(job.Name + " disabled")
+			}
+		}
+		if !enabled {
+			return
+		}
+		ticker = time.NewTicker(interval)
+		tick = ticker.C
+		slog.Info(job.Name+" enabled", "interval", interval)
+		if job.RunOnEnable {
+			job.Run(ctx, settings)
+		}
+	}
+
+	apply()
+	for {
+		select {
+		case <-ctx.Done():
+			if ticker != nil {
+				ticker.Stop()
+			}
+			return
+		case settings = <-changed:
+			apply()
+		case <-tick:
+			job.Run(ctx, settings)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// watchInterval is how often Watch checks the config file for changes
+const watchInterval = 5 * time.Second
+
+var (
+	current atomic.Pointer[Config]
+
+	// reloadMu serializes reloads and guards loadedPath and subscribers
+	reloadMu    sync.Mutex
+	loadedPath  string
+	subscribers []func(old, new *Config)
+)
+
+// Get returns the current configuration snapshot. A snapshot is never
+// modified; a reload swaps in a new one, so callers should call Get once per
+// request and use that snapshot throughout.
+func Get() *Config {
+	return current.Load()
+}
+
+// Subscribe registers fn to be called after each successful reload with the
+// previous and the new snapshot. Subscribers run one at a time and must not
+// call Reload.
+func Subscribe(fn func(old, new *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	subscribers = append(subscribers, fn)
+}
+
+// ReloadResult describes an applied reload
+type ReloadResult struct {
+	// RestartRequired lists changed fields that only take effect after a
+	// restart; the running values are kept for them
+	RestartRequired []string `json:"restart_required"`
+}
+
+// restartOnly are the sections, or single section.field paths, read once at
+// startup
+var restartOnly = []string{"server", "database", "tracing", "metrics.enabled", "logging.format"}
+
+// Reload re-reads the file Load read and the environment, validates the
+// result and swaps it in. On error the current configuration stays in place.
+func Reload() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	next, err := parse(loadedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	old := Get()
+	result := &ReloadResult{RestartRequired: diffFields(old, next, restartOnly)}
+	next.Server = old.Server
+	next.Database = old.Database
+	next.Tracing = old.Tracing
+	next.Metrics.Enabled = old.Metrics.Enabled
+	next.Logging.Format = old.Logging.Format
+
+	current.Store(next)
+	for _, fn := range subscribers {
+		fn(old, next)
+	}
+
+	if len(result.RestartRequired) > 0 {
+		slog.Warn("Config reloaded; restart required for some fields", "restart_required", result.RestartRequired)
+	} else {
+		slog.Info("Config reloaded")
+	}
+	return result, nil
+}
+
+// diffFields returns the YAML paths of the fields that differ between a and b
+// among the given paths; a section path covers all of its fields
+func diffFields(a, b *Config, paths []string) []string {
+	var changed []string
+	av, bv := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	t := av.Type()
+	for i := 0; i < t.NumField(); i++ {
+		section, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		st := t.Field(i).Type
+		if st.Kind() != reflect.Struct {
+			continue
+		}
+
+		whole := slices.Contains(paths, section)
+		for j := 0; j < st.NumField(); j++ {
+			name, _, _ := strings.Cut(st.Field(j).Tag.Get("yaml"), ",")
+			path := section + "." + name
+			if !whole && !slices.Contains(paths, path) {
+				continue
+			}
+			if !reflect.DeepEqual(av.Field(i).Field(j).Interface(), bv.Field(i).Field(j).Interface()) {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return changed
+}
+
+// Watch reloads the configuration on SIGHUP and whenever the config file
+// changes, until ctx is done. Failed reloads are logged and the current
+// configuration is kept.
+func Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	reloadMu.Lock()
+	path := loadedPath
+	reloadMu.Unlock()
+	modTime := fileModTime(path)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				slog.Info("Received SIGHUP, reloading config")
+			case <-ticker.C:
+				t := fileModTime(path)
+				if t.Equal(modTime) {
+					continue
+				}
+				modTime = t
+				slog.Info("Config file changed, reloading", "path", path)
+			}
+
+			if _, err := Reload(); err != nil {
+				slog.Error("Config reload failed, keeping the current config", "error", err)
+			}
+		}
+	}()
+}
+
+// fileModTime returns the modification time of path, zero if it has none
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Placeholder values shipped in the example configs, refused at startup
+var (
+	placeholderJWTSecrets = []string{"your_jwt_secret_key_here_change_me", "your_jwt_secret_key_here"}
+	placeholderClientIDs  = []string{"your_client_id", "yourclientid"}
+)
+
+const minJWTSecretLength = 16
+
+// Validate reports every invalid or missing setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		fail("server.port %d is out of range", c.Server.Port)
+	}
+	if c.Server.ShutdownTimeout < 0 || c.Server.DrainDelay < 0 {
+		fail("server.shutdown_timeout and server.drain_delay must not be negative")
+	}
+
+	switch c.Database.Driver {
+	case "postgres":
+		if c.Database.DSN == "" && c.Database.Host == "" {
+			fail("database.host or database.dsn is required")
+		}
+	case "sqlite":
+		if c.Database.Path == "" {
+			fail("database.path is required for the sqlite driver")
+		}
+	default:
+		fail("database.driver must be postgres or sqlite, got %q", c.Database.Driver)
+	}
+
+	endpoints := c.Upstream.AllEndpoints()
+	if len(endpoints) == 0 {
+		fail("upstream.base_url or upstream.endpoints is required")
+	}
+	upstreams := make(map[string]bool)
+	for i, e := range endpoints {
+		if e.Name == "" {
+			fail("upstream endpoint %d: name is required", i)
+		} else if upstreams[e.Name] {
+			fail("upstream endpoint %q is declared more than once", e.Name)
+		}
+		upstreams[e.Name] = true
+		if e.BaseURL == "" {
+			fail("upstream endpoint %q: base_url is required", e.Name)
+		}
+		if e.Timeout < 0 {
+			fail("upstream endpoint %q: timeout must not be negative", e.Name)
+		}
+		if err := ValidateProxyURL(e.Proxy); err != nil {
+			fail("upstream endpoint %q: %v", e.Name, err)
+		}
+		if e.TLS.CAFile != "" {
+			if _, err := os.Stat(e.TLS.CAFile); err != nil {
+				fail("upstream endpoint %q: %v", e.Name, err)
+			}
+		}
+	}
+
+	switch {
+	case c.LinuxDo.ClientID == "":
+		fail("linuxdo.client_id is required")
+	case slices.Contains(placeholderClientIDs, c.LinuxDo.ClientID):
+		fail("linuxdo.client_id is still the example placeholder")
+	}
+	if c.LinuxDo.ClientSecret == "" {
+		fail("linuxdo.client_secret is required")
+	}
+
+	switch {
+	case slices.Contains(placeholderJWTSecrets, c.JWT.Secret):
+		fail("jwt.secret is still the example placeholder")
+	case len(c.JWT.Secret) < minJWTSecretLength:
+		fail("jwt.secret must be at least %d characters", minJWTSecretLength)
+	}
+	if c.JWT.MaxSessionAge <= 0 {
+		fail("jwt.max_session_age must be positive")
+	}
+
+	if c.RateLimit.Store != "memory" && c.RateLimit.Store != "postgres" {
+		fail("rate_limit.store must be memory or postgres, got %q", c.RateLimit.Store)
+	}
+	if c.RateLimit.KeyBy != "user" && c.RateLimit.KeyBy != "api_key" {
+		fail("rate_limit.key_by must be user or api_key, got %q", c.RateLimit.KeyBy)
+	}
+	if p := c.Passthrough.PerIP; p.RequestsPerMinute < 0 || p.Burst < 0 || p.MaxConcurrentStreams < 0 {
+		fail("passthrough.per_ip limits must not be negative")
+	}
+
+	t := c.Upstream.Transport
+	if t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.ResponseHeaderTimeout < 0 || t.IdleTimeout < 0 || t.IdleConnTimeout < 0 {
+		fail("upstream.transport timeouts must not be negative")
+	}
+	if t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 {
+		fail("upstream.transport connection limits must not be negative")
+	}
+	if err := ValidateProxyURL(t.Proxy); err != nil {
+		fail("upstream.transport: %v", err)
+	}
+
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Logging.Level)) {
+		fail("logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	}
+	if !slices.Contains([]string{"json", "text"}, c.Logging.Format) {
+		fail("logging.format must be json or text, got %q", c.Logging.Format)
+	}
+
+	if !slices.Contains([]string{"none", "stdout", "otlp"}, c.Tracing.Exporter) {
+		fail("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio must be between 0 and 1")
+	}
+
+	h := c.Health
+	if h.Timeout <= 0 {
+		fail("health.timeout must be positive")
+	}
+	if h.Window <= 0 || h.Window > time.Hour {
+		fail("health.window must be between 0 and 1h")
+	}
+	if h.MinAccounts < 0 || h.MinRequests < 0 {
+		fail("health.min_accounts and health.min_requests must not be negative")
+	}
+	if h.MinSuccessRate < 0 || h.MinSuccessRate > 1 {
+		fail("health.min_success_rate must be between 0 and 1")
+	}
+
+	if c.Rewards.Interval < 0 {
+		fail("rewards.interval must not be negative")
+	}
+	if c.ModelDiscovery.Interval < 0 {
+		fail("model_discovery.interval must not be negative")
+	}
+	if c.TokenRefresh.Enabled {
+		if c.TokenRefresh.APIKey == "" {
+			fail("token_refresh.api_key is required when token refresh is enabled")
+		}
+		if u, err := url.Parse(c.TokenRefresh.TokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("token_refresh.token_url must be an http or https URL")
+		}
+		if c.TokenRefresh.Interval <= 0 || c.TokenRefresh.Before < 0 {
+			fail("token_refresh.interval must be positive and token_refresh.before not negative")
+		}
+	}
+
+	// Model IDs and aliases share one namespace
+	names := make(map[string]bool)
+	for i, m := range c.Models {
+		if m.ID == "" {
+			fail("models[%d].id is required", i)
+		}
+		for _, name := range append([]string{m.ID}, m.Aliases...) {
+			if names[name] {
+				fail("model name %q is declared more than once", name)
+			}
+			names[name] = true
+		}
+	}
+
+	for _, tag := range c.Routing.ReservedTags {
+		if err := ValidateTag(tag); err != nil {
+			fail("routing.reserved_tags: %v", err)
+		}
+	}
+	for i, r := range c.Routing.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		if len(r.Users) == 0 && len(r.APIKeys) == 0 && len(r.Models) == 0 {
+			fail("routing rule %q: users, api_keys or models is required", name)
+		}
+		for _, tag := range r.Tags {
+			if err := ValidateTag(tag); err != nil {
+				fail("routing rule %q: %v", name, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// tagPattern is the form of an account tag
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// ValidateTag accepts an account tag: up to 32 lowercase letters, digits,
+// dots, dashes and underscores, starting with a letter or digit
+func ValidateTag(tag string) error {
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	return nil
+}
+
+// ValidateProxyURL accepts an empty proxy or an http, https or socks5 URL
+func ValidateProxyURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || !slices.Contains([]string{"http", "https", "socks5"}, u.Scheme) || u.Host == "" {
+		return errors.New("proxy must be an http, https or socks5 URL")
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"cosine/models"
+)
+
+// accountColumns 是查询 accounts 时统一使用的列，顺序与 scanAccount 一致
+const accountColumns = `id, auth, team_id, linuxdo_id, is_active, paused, request_count,
+		last_used_at, last_error, last_error_at, fail_count, upstream, proxy, weight, tags, refresh_token, disabled_by, created_at, updated_at`
+
+// healthSpan 返回维护账户健康时长的 SET 子句，捐赠积分按健康时长发放。
+// healthy 是以更新前的列值表示的更新后账户是否健康（启用、未暂停、无连续失败）
+// 的 SQL 条件：变为不健康时把 healthy_since 起的时长累加进 healthy_seconds，
+// 变为健康时从现在开始计时
+func (s *sqlStore) healthSpan(healthy string) string {
+	return `healthy_seconds = healthy_seconds + CASE WHEN ` + healthy + ` THEN 0
+			ELSE COALESCE(` + s.d.secondsSince("healthy_since") + `, 0) END,
+		healthy_since = CASE WHEN ` + healthy + ` THEN COALESCE(healthy_since, ` + s.d.now() + `) END`
+}
+
+func scanAccount(row rowScanner) (*models.Account, error) {
+	var acc models.Account
+	var lastError sql.NullString
+	var tags string
+	err := row.Scan(
+		&acc.ID, &acc.Auth, &acc.TeamID, &acc.LinuxdoID, &acc.IsActive, &acc.Paused, &acc.RequestCount,
+		&acc.LastUsedAt, &lastError, &acc.LastErrorAt, &acc.FailCount, &acc.Upstream, &acc.Proxy,
+		&acc.Weight, &tags, &acc.RefreshToken, &acc.DisabledBy, &acc.CreatedAt, &acc.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	acc.LastError = lastError.String
+	acc.Tags = splitTags(tags)
+	return &acc, nil
+}
+
+func scanAccounts(rows *sql.Rows) ([]models.Account, error) {
+	defer rows.Close()
+
+	var accounts []models.Account
+	for rows.Next() {
+		acc, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *acc)
+	}
+
+	return accounts, rows.Err()
+}
+
+// GetActiveAccounts 获取所有活跃且未暂停的账户
+func (s *sqlStore) GetActiveAccounts() ([]models.Account, error) {
+	rows, err := s.db.Query(`
+		SELECT ` + accountColumns + `
+		FROM accounts
+		WHERE is_active = true AND paused = false
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAccounts(rows)
+}
+
+func (s *sqlStore) DeactivateAccount(accountID int, by string) error {
+	_, err := s.db.Exec(`
+		UPDATE accounts
+		SET is_active = false, disabled_by = $2, `+s.healthSpan("false")+`, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, by)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate account %d: %w", accountID, err)
+	}
+
+	slog.Info("Account deactivated", "account_id", accountID, "disabled_by", by)
+	return nil
+}
+
+func (s *sqlStore) GetAccountCount() (int, error) {
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM accounts WHERE is_active = true AND paused = false`).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) CreateAccount(auth, teamID string, linuxdoID int) (*models.Account, error) {
+	acc := &models.Account{Auth: auth, TeamID: teamID, IsActive: true}
+	if linuxdoID != 0 {
+		acc.LinuxdoID = &linuxdoID
+	}
+	return s.InsertAccount(acc)
+}
+
+func (s *sqlStore) InsertAccount(acc *models.Account) (*models.Account, error) {
+	weight := acc.Weight
+	if weight <= 0 {
+		weight = 1
+	}
+	created, err := scanAccount(s.db.QueryRow(`
+		INSERT INTO accounts (auth, team_id, linuxdo_id, is_active, disabled_by, refresh_token, weight, tags,
+			upstream, proxy, healthy_since, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, CASE WHEN $4 THEN `+s.d.now()+` END,
+			CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING `+accountColumns,
+		acc.Auth, acc.TeamID, acc.LinuxdoID, acc.IsActive, acc.DisabledBy, acc.RefreshToken, weight,
+		strings.Join(acc.Tags, ","), acc.Upstream, acc.Proxy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create account: %w", err)
+	}
+
+	if created.LinuxdoID != nil {
+		slog.Info("New account donated", "account_id", created.ID, "linuxdo_id", *created.LinuxdoID)
+	} else {
+		slog.Info("New pool account added", "account_id", created.ID)
+	}
+	return created, nil
+}
+
+func (s *sqlStore) GetAccountsByLinuxdoID(linuxdoID int) ([]models.Account, error) {
+	rows, err := s.db.Query(`
+		SELECT `+accountColumns+`
+		FROM accounts
+		WHERE linuxdo_id = $1
+		ORDER BY created_at DESC, id DESC
+	`, linuxdoID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAccounts(rows)
+}
+
+func (s *sqlStore) RecordAccountSuccess(accountID int) error {
+	_, err := s.db.Exec(`
+		UPDATE accounts
+		SET last_used_at = CURRENT_TIMESTAMP, fail_count = 0, request_count = request_count + 1,
+			`+s.healthSpan("is_active AND NOT paused")+`
+		WHERE id = $1
+	`, accountID)
+	return err
+}
+
+func (s *sqlStore) RecordAccountFailure(accountID int, reason string) error {
+	_, err := s.db.Exec(`
+		UPDATE accounts
+		SET last_error = $2, last_error_at = CURRENT_TIMESTAMP, fail_count = fail_count + 1,
+			`+s.healthSpan("false")+`
+		WHERE id = $1
+	`, accountID, reason)
+	return err
+}
+
+func (s *sqlStore) ListAccounts(linuxdoID *int) ([]models.Account, error) {
+	rows, err := s.db.Query(`
+		SELECT `+accountColumns+`
+		FROM accounts
+		WHERE CAST($1 AS INTEGER) IS NULL OR linuxdo_id = $1
+		ORDER BY id
+	`, linuxdoID)
+	if err != nil {
+		return nil, err
+	}
+	return scanAccounts(rows)
+}
+
+func (s *sqlStore) ActivateAccount(accountID int) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET is_active = true, disabled_by = '', fail_count = 0, `+s.healthSpan("NOT paused")+`,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to activate account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) DeleteAccount(accountID int) error {
+	res, err := s.db.Exec(`DELETE FROM accounts WHERE id = $1`, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to delete account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountPaused(accountID int, paused bool) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET paused = $2, `+s.healthSpan("is_active AND fail_count = 0 AND NOT $2")+`, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, paused)
+	if err != nil {
+		return fmt.Errorf("failed to update account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) UpdateAccountCredential(accountID int, auth, teamID, refreshToken string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET auth = $2, team_id = COALESCE(NULLIF($3, ''), team_id), refresh_token = $4, fail_count = 0,
+			is_active = CASE WHEN disabled_by = $5 THEN true ELSE is_active END,
+			disabled_by = CASE WHEN disabled_by = $5 THEN '' ELSE disabled_by END,
+			`+s.healthSpan("(is_active OR disabled_by = $5) AND NOT paused")+`,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, auth, teamID, refreshToken, models.DisabledByUpstream)
+	if err != nil {
+		return fmt.Errorf("failed to update credential of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountRefreshToken(accountID int, refreshToken string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET refresh_token = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to update refresh token of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+// ReplaceAccountToken 仅在账户当前凭证仍为 oldAuth 时换上刷新得到的凭证，
+// 并发刷新时只有一个生效，其余返回 sql.ErrNoRows
+func (s *sqlStore) ReplaceAccountToken(accountID int, oldAuth, auth, refreshToken string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET auth = $3, refresh_token = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND auth = $2
+	`, accountID, oldAuth, auth, refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to replace token of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountUpstream(accountID int, upstream string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET upstream = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, upstream)
+	if err != nil {
+		return fmt.Errorf("failed to update upstream of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountProxy(accountID int, proxy string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET proxy = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, proxy)
+	if err != nil {
+		return fmt.Errorf("failed to update proxy of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountWeight(accountID, weight int) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET weight = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, weight)
+	if err != nil {
+		return fmt.Errorf("failed to update weight of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+func (s *sqlStore) SetAccountTags(accountID int, tags []string) error {
+	res, err := s.db.Exec(`
+		UPDATE accounts
+		SET tags = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, accountID, strings.Join(tags, ","))
+	if err != nil {
+		return fmt.Errorf("failed to update tags of account %d: %w", accountID, err)
+	}
+
+	return requireAffected(res)
+}
+
+// splitTags 解析以逗号分隔存储的标签
+func splitTags(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func (s *sqlStore) GetAccountByID(accountID int) (*models.Account, error) {
+	return scanAccount(s.db.QueryRow(`
+		SELECT `+accountColumns+`
+		FROM accounts
+		WHERE id = $1
+	`, accountID))
+}
+
+// Donation 汇总单个用户捐赠的账户
+type Donation struct {
+	LinuxDoID  int    `json:"linuxdo_id"`
+	Username   string `json:"username"`
+	Total      int    `json:"total"`
+	Active     int    `json:"active"`
+	AccountIDs []int  `json:"account_ids"`
+}
+
+func (s *sqlStore) ListDonations() ([]Donation, error) {
+	rows, err := s.db.Query(`
+		SELECT a.id, a.linuxdo_id, a.is_active, COALESCE(u.username, '')
+		FROM accounts a
+		LEFT JOIN linuxdo_user u ON u.linuxdo_id = a.linuxdo_id
+		WHERE a.linuxdo_id IS NOT NULL
+		ORDER BY a.linuxdo_id, a.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var donations []Donation
+	for rows.Next() {
+		var (
+			accountID, linuxdoID int
+			isActive             bool
+			username             string
+		)
+		if err := rows.Scan(&accountID, &linuxdoID, &isActive, &username); err != nil {
+			return nil, err
+		}
+
+		if len(donations) == 0 || donations[len(donations)-1].LinuxDoID != linuxdoID {
+			donations = append(donations, Donation{LinuxDoID: linuxdoID, Username: username})
+		}
+		d := &donations[len(donations)-1]
+		d.Total++
+		if isActive {
+			d.Active++
+		}
+		d.AccountIDs = append(d.AccountIDs, accountID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(donations, func(i, j int) bool {
+		return donations[i].Total > donations[j].Total
+	})
+	return donations, nil
+}
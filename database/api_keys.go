@@ -0,0 +1,89 @@
+package database
+
+import "time"
+
+// APIKey is a long-lived credential a user can use instead of a JWT.
+// Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         int        `json:"id"`
+	LinuxDoID  int        `json:"linuxdo_id"`
+	Name       string     `json:"name"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Revoked    bool       `json:"revoked"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+const apiKeyColumns = `id, linuxdo_id, name, key_prefix, revoked, last_used_at, created_at`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.LinuxDoID, &k.Name, &k.KeyPrefix, &k.Revoked, &k.LastUsedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateAPIKey stores a new API key for a user
+func (s *sqlStore) CreateAPIKey(linuxDoID int, name, keyHash, keyPrefix string) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(`
+		INSERT INTO api_keys (linuxdo_id, name, key_hash, key_prefix, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		RETURNING `+apiKeyColumns,
+		linuxDoID, name, keyHash, keyPrefix,
+	))
+}
+
+// GetAPIKeyByHash looks up an unrevoked API key by the hash of its value
+func (s *sqlStore) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRow(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked = false
+	`, keyHash))
+}
+
+// ListAPIKeys returns all API keys of a user, newest first
+func (s *sqlStore) ListAPIKeys(linuxDoID int) ([]APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE linuxdo_id = $1
+		ORDER BY id DESC
+	`, linuxDoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func (s *sqlStore) RevokeAPIKey(id, linuxDoID int) error {
+	res, err := s.db.Exec(`
+		UPDATE api_keys
+		SET revoked = true
+		WHERE id = $1 AND linuxdo_id = $2
+	`, id, linuxDoID)
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// TouchAPIKey records that an API key was just used
+func (s *sqlStore) TouchAPIKey(id int) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	return err
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Credit reasons recorded in the quota_credits ledger
+const (
+	CreditReasonDonation = "donation"
+	CreditReasonUsage    = "usage"
+	CreditReasonRefund   = "refund"
+)
+
+// QuotaCredit is one entry of the credit ledger. Earned credits are
+// positive, consumed credits negative.
+type QuotaCredit struct {
+	ID        int64     `json:"id"`
+	LinuxDoID int       `json:"linuxdo_id"`
+	AccountID *int      `json:"account_id"`
+	Requests  int64     `json:"requests"`
+	Tokens    int64     `json:"tokens"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreditBalance is the sum of a user's ledger entries
+type CreditBalance struct {
+	Requests int64 `json:"requests"`
+	Tokens   int64 `json:"tokens"`
+}
+
+// GrantDonationRewards credits donors for the time their accounts stayed healthy
+// since the previous run. Every account tracks its healthy time as it goes
+// through failures, pauses and deactivation, so a single run does not credit
+// the whole period from one health check. The credited time is capped at
+// maxPeriod so downtime of this service earns little. It returns the number
+// of accounts that earned credits.
+func (s *sqlStore) GrantDonationRewards(requestsPerHour, tokensPerHour float64, maxPeriod time.Duration) (int, error) {
+	defer observeOperation(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, linuxdo_id, healthy_seconds + COALESCE(` + s.d.secondsSince("healthy_since") + `, 0)
+		FROM accounts
+		WHERE linuxdo_id IS NOT NULL
+		` + s.d.forUpdate())
+	if err != nil {
+		return 0, err
+	}
+
+	type due struct {
+		accountID, linuxdoID int
+		hours                float64
+	}
+	var dues []due
+	for rows.Next() {
+		var (
+			d       due
+			seconds float64
+		)
+		if err := rows.Scan(&d.accountID, &d.linuxdoID, &seconds); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		d.hours = math.Min(seconds, maxPeriod.Seconds()) / 3600
+		dues = append(dues, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	granted := 0
+	for _, d := range dues {
+		requests := int64(math.Round(requestsPerHour * d.hours))
+		tokens := int64(math.Round(tokensPerHour * d.hours))
+		if requests == 0 && tokens == 0 {
+			continue
+		}
+		_, err := tx.Exec(`
+			INSERT INTO quota_credits (linuxdo_id, account_id, requests, tokens, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP)
+		`, d.linuxdoID, d.accountID, requests, tokens, CreditReasonDonation)
+		if err != nil {
+			return 0, fmt.Errorf("failed to credit account %d: %w", d.accountID, err)
+		}
+		granted++
+	}
+
+	// Every donated account starts counting again, so no time is credited twice
+	_, err = tx.Exec(`
+		UPDATE accounts
+		SET rewarded_at = CURRENT_TIMESTAMP, healthy_seconds = 0,
+			healthy_since = CASE WHEN healthy_since IS NOT NULL THEN ` + s.d.now() + ` END
+		WHERE linuxdo_id IS NOT NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	return granted, tx.Commit()
+}
+
+// GetCreditBalance returns the remaining credits of a user
+func (s *sqlStore) GetCreditBalance(linuxDoID int) (*CreditBalance, error) {
+	var b CreditBalance
+	err := s.db.QueryRow(`
+		SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(tokens), 0)
+		FROM quota_credits
+		WHERE linuxdo_id = $1
+	`, linuxDoID).Scan(&b.Requests, &b.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ConsumeCredits deducts up to the given amounts from a user's balance and
+// returns what was actually deducted. Concurrent calls for the same user are
+// serialized so the balance never goes negative.
+func (s *sqlStore) ConsumeCredits(linuxDoID int, requests, tokens int64) (*CreditBalance, error) {
+	defer observeOperation(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := s.d.lockTx(tx, creditLockKey(linuxDoID)); err != nil {
+		return nil, err
+	}
+
+	var balance CreditBalance
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(tokens), 0)
+		FROM quota_credits
+		WHERE linuxdo_id = $1
+	`, linuxDoID).Scan(&balance.Requests, &balance.Tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	used := CreditBalance{
+		Requests: max(0, min(requests, balance.Requests)),
+		Tokens:   max(0, min(tokens, balance.Tokens)),
+	}
+	if used.Requests == 0 && used.Tokens == 0 {
+		return &used, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO quota_credits (linuxdo_id, requests, tokens, reason, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	`, linuxDoID, -used.Requests, -used.Tokens, CreditReasonUsage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &used, tx.Commit()
+}
+
+// RefundCredits gives back credits consumed by a request that failed
+func (s *sqlStore) RefundCredits(linuxDoID int, requests, tokens int64) error {
+	_, err := s.db.Exec(`
+		INSERT INTO quota_credits (linuxdo_id, requests, tokens, reason, created_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+	`, linuxDoID, requests, tokens, CreditReasonRefund)
+	return err
+}
+
+// ListCredits returns the most recent ledger entries of a user
+func (s *sqlStore) ListCredits(linuxDoID, limit int) ([]QuotaCredit, error) {
+	rows, err := s.db.Query(`
+		SELECT id, linuxdo_id, account_id, requests, tokens, reason, created_at
+		FROM quota_credits
+		WHERE linuxdo_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, linuxDoID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credits []QuotaCredit
+	for rows.Next() {
+		var qc QuotaCredit
+		err := rows.Scan(&qc.ID, &qc.LinuxDoID, &qc.AccountID, &qc.Requests, &qc.Tokens, &qc.Reason, &qc.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		credits = append(credits, qc)
+	}
+
+	return credits, rows.Err()
+}
+
+// creditLockKey namespaces the lock serializing changes to a user's credit ledger
+func creditLockKey(linuxDoID int) int64 {
+	return 0x63726564<<32 | int64(uint32(linuxDoID))
+}
@@ -13,38 +13,45 @@ type LinuxDoUser struct {
 	Name       string    `json:"name"`
 	TrustLevel int       `json:"trust_level"`
 	Active     bool      `json:"active"`
+	Silenced   bool      `json:"silenced"`
+	IsAdmin    bool      `json:"is_admin"`
+	Banned     bool      `json:"banned"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
-// CreateOrUpdateLinuxDoUser creates a new user or updates existing one based on linuxdo_id
-func CreateOrUpdateLinuxDoUser(linuxDoID int, username, name string, trustLevel int, active bool) (*LinuxDoUser, error) {
+// userColumns lists the linuxdo_user columns in the order scanUser expects
+const userColumns = `id, linuxdo_id, username, name, trust_level, active, silenced,
+		is_admin, banned, created_at, updated_at`
+
+func scanUser(row rowScanner) (*LinuxDoUser, error) {
 	var user LinuxDoUser
+	var name sql.NullString
+	err := row.Scan(
+		&user.ID, &user.LinuxDoID, &user.Username, &name,
+		&user.TrustLevel, &user.Active, &user.Silenced,
+		&user.IsAdmin, &user.Banned, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user.Name = name.String
+	return &user, nil
+}
 
+// CreateOrUpdateLinuxDoUser creates a new user or updates existing one based on linuxdo_id
+func (s *sqlStore) CreateOrUpdateLinuxDoUser(linuxDoID int, username, name string, trustLevel int, active, silenced bool) (*LinuxDoUser, error) {
 	// Try to find existing user
-	err := db.QueryRow(`
-		SELECT id, linuxdo_id, username, name, trust_level, active, created_at, updated_at
-		FROM linuxdo_user
-		WHERE linuxdo_id = $1
-	`, linuxDoID).Scan(
-		&user.ID, &user.LinuxDoID, &user.Username, &user.Name,
-		&user.TrustLevel, &user.Active, &user.CreatedAt, &user.UpdatedAt,
-	)
+	_, err := s.GetLinuxDoUserByLinuxDoID(linuxDoID)
 
 	if err == sql.ErrNoRows {
 		// Create new user
-		err = db.QueryRow(`
-			INSERT INTO linuxdo_user (linuxdo_id, username, name, trust_level, active, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
-			RETURNING id, linuxdo_id, username, name, trust_level, active, created_at, updated_at
-		`, linuxDoID, username, name, trustLevel, active).Scan(
-			&user.ID, &user.LinuxDoID, &user.Username, &user.Name,
-			&user.TrustLevel, &user.Active, &user.CreatedAt, &user.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		return &user, nil
+		return scanUser(s.db.QueryRow(`
+			INSERT INTO linuxdo_user (linuxdo_id, username, name, trust_level, active, silenced, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			RETURNING `+userColumns,
+			linuxDoID, username, name, trustLevel, active, silenced,
+		))
 	}
 
 	if err != nil {
@@ -52,35 +59,59 @@ func CreateOrUpdateLinuxDoUser(linuxDoID int, username, name string, trustLevel
 	}
 
 	// Update existing user
-	err = db.QueryRow(`
+	return scanUser(s.db.QueryRow(`
 		UPDATE linuxdo_user
-		SET username = $2, name = $3, trust_level = $4, active = $5, updated_at = NOW()
+		SET username = $2, name = $3, trust_level = $4, active = $5, silenced = $6, updated_at = CURRENT_TIMESTAMP
 		WHERE linuxdo_id = $1
-		RETURNING id, linuxdo_id, username, name, trust_level, active, created_at, updated_at
-	`, linuxDoID, username, name, trustLevel, active).Scan(
-		&user.ID, &user.LinuxDoID, &user.Username, &user.Name,
-		&user.TrustLevel, &user.Active, &user.CreatedAt, &user.UpdatedAt,
-	)
+		RETURNING `+userColumns,
+		linuxDoID, username, name, trustLevel, active, silenced,
+	))
+}
+
+// GetLinuxDoUserByLinuxDoID retrieves a user by their LinuxDo ID
+func (s *sqlStore) GetLinuxDoUserByLinuxDoID(linuxDoID int) (*LinuxDoUser, error) {
+	return scanUser(s.db.QueryRow(`
+		SELECT `+userColumns+`
+		FROM linuxdo_user
+		WHERE linuxdo_id = $1
+	`, linuxDoID))
+}
+
+// ListLinuxDoUsers returns users whose username or name contains query, newest first
+func (s *sqlStore) ListLinuxDoUsers(query string, limit, offset int) ([]LinuxDoUser, error) {
+	rows, err := s.db.Query(`
+		SELECT `+userColumns+`
+		FROM linuxdo_user
+		WHERE $1 = '' OR LOWER(username) LIKE '%' || LOWER($1) || '%' OR LOWER(name) LIKE '%' || LOWER($1) || '%'
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &user, nil
+	var users []LinuxDoUser
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, rows.Err()
 }
 
-// GetLinuxDoUserByLinuxDoID retrieves a user by their LinuxDo ID
-func GetLinuxDoUserByLinuxDoID(linuxDoID int) (*LinuxDoUser, error) {
-	var user LinuxDoUser
-	err := db.QueryRow(`
-		SELECT id, linuxdo_id, username, name, trust_level, active, created_at, updated_at
-		FROM linuxdo_user
+// SetLinuxDoUserBanned bans or unbans a user
+func (s *sqlStore) SetLinuxDoUserBanned(linuxDoID int, banned bool) error {
+	res, err := s.db.Exec(`
+		UPDATE linuxdo_user
+		SET banned = $2, updated_at = CURRENT_TIMESTAMP
 		WHERE linuxdo_id = $1
-	`, linuxDoID).Scan(
-		&user.ID, &user.LinuxDoID, &user.Username, &user.Name,
-		&user.TrustLevel, &user.Active, &user.CreatedAt, &user.UpdatedAt,
-	)
+	`, linuxDoID, banned)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return &user, nil
+	return requireAffected(res)
 }
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS linuxdo_user;
+DROP TABLE IF EXISTS accounts;
@@ -1,4 +1,5 @@
--- Cosine2API Database Schema
+-- Baseline schema, identical to the former init.sql so existing deployments
+-- created from it are picked up without changes
 
 CREATE TABLE IF NOT EXISTS accounts (
     id SERIAL PRIMARY KEY,
@@ -10,10 +11,8 @@ CREATE TABLE IF NOT EXISTS accounts (
     updated_at TIMESTAMP DEFAULT NOW()
 );
 
--- Index for faster queries on active accounts
 CREATE INDEX IF NOT EXISTS idx_accounts_is_active ON accounts(is_active);
 
--- LinuxDo Users Table
 CREATE TABLE IF NOT EXISTS linuxdo_user (
     id SERIAL PRIMARY KEY,
     linuxdo_id INTEGER UNIQUE NOT NULL,
@@ -26,6 +25,3 @@ CREATE TABLE IF NOT EXISTS linuxdo_user (
 );
 
 CREATE INDEX IF NOT EXISTS idx_linuxdo_user_linuxdo_id ON linuxdo_user(linuxdo_id);
-
--- Example insert (replace with your actual values)
--- INSERT INTO accounts (auth, team_id) VALUES ('your_firebase_session_token', 'your_team_id');
@@ -0,0 +1,3 @@
+ALTER TABLE linuxdo_user DROP COLUMN IF EXISTS banned;
+ALTER TABLE linuxdo_user DROP COLUMN IF EXISTS is_admin;
+ALTER TABLE linuxdo_user DROP COLUMN IF EXISTS silenced;
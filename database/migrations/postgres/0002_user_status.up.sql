@@ -0,0 +1,3 @@
+ALTER TABLE linuxdo_user ADD COLUMN IF NOT EXISTS silenced BOOLEAN DEFAULT false;
+ALTER TABLE linuxdo_user ADD COLUMN IF NOT EXISTS is_admin BOOLEAN DEFAULT false;
+ALTER TABLE linuxdo_user ADD COLUMN IF NOT EXISTS banned BOOLEAN DEFAULT false;
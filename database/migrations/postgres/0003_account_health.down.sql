@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_accounts_linuxdo_id;
+
+ALTER TABLE accounts DROP COLUMN IF EXISTS rewarded_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS fail_count;
+ALTER TABLE accounts DROP COLUMN IF EXISTS last_error_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS last_error;
+ALTER TABLE accounts DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE accounts DROP COLUMN IF EXISTS request_count;
+ALTER TABLE accounts DROP COLUMN IF EXISTS paused;
@@ -0,0 +1,9 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS paused BOOLEAN DEFAULT false;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS request_count INTEGER DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS last_error_at TIMESTAMP;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS fail_count INTEGER DEFAULT 0;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS rewarded_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_accounts_linuxdo_id ON accounts(linuxdo_id);
@@ -0,0 +1,12 @@
+-- Donors earn credits, chat usage beyond the daily quota spends them
+CREATE TABLE IF NOT EXISTS quota_credits (
+    id BIGSERIAL PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    account_id INTEGER,
+    requests BIGINT NOT NULL DEFAULT 0,
+    tokens BIGINT NOT NULL DEFAULT 0,
+    reason VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_credits_linuxdo_id ON quota_credits(linuxdo_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS rate_limit_slots;
+DROP TABLE IF EXISTS rate_limit_counters;
+DROP TABLE IF EXISTS rate_limit_buckets;
+DROP TABLE IF EXISTS api_keys;
@@ -0,0 +1,34 @@
+-- Only the SHA-256 hash of each API key is stored
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    key_prefix VARCHAR(32) NOT NULL,
+    revoked BOOLEAN DEFAULT false,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_linuxdo_id ON api_keys(linuxdo_id);
+
+-- Rate limit state shared between instances (rate_limit.store: postgres)
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT PRIMARY KEY,
+    value BIGINT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_slots (
+    id BIGSERIAL PRIMARY KEY,
+    key TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_slots_key ON rate_limit_slots(key);
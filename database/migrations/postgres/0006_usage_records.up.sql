@@ -0,0 +1,17 @@
+-- One row per chat request
+CREATE TABLE IF NOT EXISTS usage_records (
+    id BIGSERIAL PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    api_key_id INTEGER,
+    model VARCHAR(100) NOT NULL,
+    account_id INTEGER,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    status_code INTEGER NOT NULL,
+    stream BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_records_linuxdo_id ON usage_records(linuxdo_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_records_created_at ON usage_records(created_at);
@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS proxy TEXT NOT NULL DEFAULT '';
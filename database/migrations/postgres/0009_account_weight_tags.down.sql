@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN IF EXISTS tags;
+ALTER TABLE accounts DROP COLUMN IF EXISTS weight;
@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS weight INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS tags TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS refresh_token TEXT NOT NULL DEFAULT '';
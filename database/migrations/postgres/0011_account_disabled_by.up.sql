@@ -0,0 +1,6 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS disabled_by TEXT NOT NULL DEFAULT '';
+
+-- 已停用的账户：被上游拒绝的记为 upstream，其余视为管理员停用
+UPDATE accounts
+SET disabled_by = CASE WHEN last_error IN ('upstream status 401', 'upstream status 403') THEN 'upstream' ELSE 'admin' END
+WHERE is_active = false;
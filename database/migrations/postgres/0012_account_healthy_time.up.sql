@@ -0,0 +1,7 @@
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS healthy_since TIMESTAMP;
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS healthy_seconds DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- 当前健康的账户从上次发放积分起计时，与之前的发放方式衔接
+UPDATE accounts
+SET healthy_since = COALESCE(rewarded_at, created_at)
+WHERE is_active AND NOT paused AND fail_count = 0;
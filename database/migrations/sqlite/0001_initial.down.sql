@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS usage_records;
+DROP TABLE IF EXISTS rate_limit_slots;
+DROP TABLE IF EXISTS rate_limit_counters;
+DROP TABLE IF EXISTS rate_limit_buckets;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS quota_credits;
+DROP TABLE IF EXISTS linuxdo_user;
+DROP TABLE IF EXISTS accounts;
@@ -0,0 +1,97 @@
+-- Full schema in one step; it matches the Postgres schema after 0006_usage_records
+
+CREATE TABLE IF NOT EXISTS accounts (
+    id INTEGER PRIMARY KEY,
+    auth TEXT NOT NULL,
+    team_id VARCHAR(50) NOT NULL,
+    linuxdo_id INTEGER,
+    is_active BOOLEAN DEFAULT true,
+    paused BOOLEAN DEFAULT false,
+    request_count INTEGER DEFAULT 0,
+    last_used_at TIMESTAMP,
+    last_error TEXT,
+    last_error_at TIMESTAMP,
+    fail_count INTEGER DEFAULT 0,
+    rewarded_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_accounts_is_active ON accounts(is_active);
+CREATE INDEX IF NOT EXISTS idx_accounts_linuxdo_id ON accounts(linuxdo_id);
+
+CREATE TABLE IF NOT EXISTS linuxdo_user (
+    id INTEGER PRIMARY KEY,
+    linuxdo_id INTEGER UNIQUE NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    name VARCHAR(255),
+    trust_level INTEGER DEFAULT 0,
+    active BOOLEAN DEFAULT true,
+    silenced BOOLEAN DEFAULT false,
+    is_admin BOOLEAN DEFAULT false,
+    banned BOOLEAN DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS quota_credits (
+    id INTEGER PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    account_id INTEGER,
+    requests BIGINT NOT NULL DEFAULT 0,
+    tokens BIGINT NOT NULL DEFAULT 0,
+    reason VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_credits_linuxdo_id ON quota_credits(linuxdo_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INTEGER PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    key_prefix VARCHAR(32) NOT NULL,
+    revoked BOOLEAN DEFAULT false,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_linuxdo_id ON api_keys(linuxdo_id);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    key TEXT PRIMARY KEY,
+    value BIGINT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_slots (
+    id INTEGER PRIMARY KEY,
+    key TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_slots_key ON rate_limit_slots(key);
+
+CREATE TABLE IF NOT EXISTS usage_records (
+    id INTEGER PRIMARY KEY,
+    linuxdo_id INTEGER NOT NULL,
+    api_key_id INTEGER,
+    model VARCHAR(100) NOT NULL,
+    account_id INTEGER,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    latency_ms BIGINT NOT NULL DEFAULT 0,
+    status_code INTEGER NOT NULL,
+    stream BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_records_linuxdo_id ON usage_records(linuxdo_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_usage_records_created_at ON usage_records(created_at);
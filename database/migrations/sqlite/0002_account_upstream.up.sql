@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN upstream VARCHAR(64) NOT NULL DEFAULT '';
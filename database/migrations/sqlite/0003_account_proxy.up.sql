@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN proxy TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN tags;
+ALTER TABLE accounts DROP COLUMN weight;
@@ -0,0 +1,2 @@
+ALTER TABLE accounts ADD COLUMN weight INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE accounts ADD COLUMN tags TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE accounts ADD COLUMN refresh_token TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN healthy_seconds;
+ALTER TABLE accounts DROP COLUMN healthy_since;
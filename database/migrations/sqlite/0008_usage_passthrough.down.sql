@@ -0,0 +1 @@
+ALTER TABLE usage_records DROP COLUMN passthrough;
@@ -0,0 +1,2 @@
+-- 调用方自带 Cosine 凭证的请求不属于任何用户和账户
+ALTER TABLE usage_records ADD COLUMN passthrough BOOLEAN NOT NULL DEFAULT false;
@@ -1,170 +1,122 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
-	"sync"
-	"sync/atomic"
+	"log/slog"
+	"time"
 
 	"cosine/config"
-	"cosine/models"
 
 	_ "github.com/lib/pq"
 )
 
-var (
-	db      *sql.DB
-	counter uint64
-	mu      sync.RWMutex
+const (
+	defaultConnectTimeout = time.Minute
+	connectRetryMin       = 500 * time.Millisecond
+	connectRetryMax       = 10 * time.Second
+	connectAttemptTimeout = 5 * time.Second
 )
 
-func Init(cfg *config.DatabaseConfig) error {
-	dsn := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
-	)
+// OpenPostgres 连接 Postgres 数据库，按配置设置连接池
+func OpenPostgres(cfg *config.DatabaseConfig) (Store, error) {
+	dsn := cfg.DSN
+	if dsn == "" {
+		dsn = fmt.Sprintf(
+			"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode,
+		)
+	}
 
-	var err error
-	db, err = sql.Open("postgres", dsn)
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err = db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+	// 零值保留 database/sql 的默认设置
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
 	}
-
-	log.Println("Database connected successfully")
-	return nil
-}
-
-func Close() {
-	if db != nil {
-		db.Close()
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
 	}
-}
-
-// GetNextAccount 使用 Round-Robin 获取下一个可用账户
-func GetNextAccount() (*models.Account, error) {
-	mu.RLock()
-	defer mu.RUnlock()
-
-	accounts, err := getActiveAccounts()
-	if err != nil {
-		return nil, err
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 	}
 
-	if len(accounts) == 0 {
-		return nil, fmt.Errorf("no active accounts available")
+	if err = pingWithRetry(db, cfg.ConnectTimeout); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	idx := atomic.AddUint64(&counter, 1) % uint64(len(accounts))
-	return &accounts[idx], nil
+	slog.Info("Database connected")
+	return &sqlStore{db: timedDB{db}, d: postgresDialect{}}, nil
 }
 
-// getActiveAccounts 获取所有活跃账户
-func getActiveAccounts() ([]models.Account, error) {
-	rows, err := db.Query(`
-		SELECT id, auth, team_id, linuxdo_id, is_active, created_at, updated_at
-		FROM accounts
-		WHERE is_active = true
-		ORDER BY id
-	`)
-	if err != nil {
-		return nil, err
+// pingWithRetry 在 timeout 内以指数退避重试连接，数据库晚于服务启动时不会直接退出
+func pingWithRetry(db *sql.DB, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
 	}
-	defer rows.Close()
-
-	var accounts []models.Account
-	for rows.Next() {
-		var acc models.Account
-		err := rows.Scan(
-			&acc.ID, &acc.Auth, &acc.TeamID, &acc.LinuxdoID,
-			&acc.IsActive, &acc.CreatedAt, &acc.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
+	deadline := time.Now().Add(timeout)
+	wait := connectRetryMin
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), connectAttemptTimeout)
+		err := db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
 		}
-		accounts = append(accounts, acc)
-	}
 
-	return accounts, nil
+		wait = min(wait, remaining)
+		slog.Warn("Database not ready, retrying", "wait", wait, "error", err)
+		time.Sleep(wait)
+		wait = min(wait*2, connectRetryMax)
+	}
 }
 
-// DeactivateAccount 将账户标记为不活跃
-func DeactivateAccount(accountID int) error {
-	mu.Lock()
-	defer mu.Unlock()
+type postgresDialect struct{}
 
-	_, err := db.Exec(`
-		UPDATE accounts
-		SET is_active = false, updated_at = NOW()
-		WHERE id = $1
-	`, accountID)
-	if err != nil {
-		return fmt.Errorf("failed to deactivate account %d: %w", accountID, err)
-	}
+func (postgresDialect) migrationDir() string { return "postgres" }
 
-	log.Printf("Account %d has been deactivated", accountID)
-	return nil
+// lockMigrations 在整个迁移期间持有会话级 advisory lock，多个实例同时启动时每个迁移只执行一次
+func (postgresDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+	}, nil
 }
 
-// GetAccountCount 获取活跃账户数量
-func GetAccountCount() (int, error) {
-	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM accounts WHERE is_active = true`).Scan(&count)
-	return count, err
+func (postgresDialect) lockTx(tx *sql.Tx, key int64) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, key)
+	return err
 }
 
-// CreateAccount 创建新的捐赠账户
-func CreateAccount(auth, teamID string, linuxdoID int) (*models.Account, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	var acc models.Account
-
-	err := db.QueryRow(`
-		INSERT INTO accounts (auth, team_id, linuxdo_id, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, true, NOW(), NOW())
-		RETURNING id, auth, team_id, linuxdo_id, is_active, created_at, updated_at
-	`, auth, teamID, linuxdoID).Scan(
-		&acc.ID, &acc.Auth, &acc.TeamID, &acc.LinuxdoID,
-		&acc.IsActive, &acc.CreatedAt, &acc.UpdatedAt,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create account: %w", err)
-	}
+func (postgresDialect) forUpdate() string { return "FOR UPDATE" }
+
+func (postgresDialect) now() string { return "NOW()" }
 
-	log.Printf("New account donated by linuxdo_id: %d", linuxdoID)
-	return &acc, nil
+func (postgresDialect) nowPlusSeconds(expr string) string {
+	return "NOW() + " + expr + " * INTERVAL '1 second'"
 }
 
-// GetAccountsByLinuxdoID 获取指定用户捐赠的所有账户
-func GetAccountsByLinuxdoID(linuxdoID int) ([]models.Account, error) {
-	rows, err := db.Query(`
-		SELECT id, auth, team_id, linuxdo_id, is_active, created_at, updated_at
-		FROM accounts
-		WHERE linuxdo_id = $1
-		ORDER BY created_at DESC
-	`, linuxdoID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var accounts []models.Account
-	for rows.Next() {
-		var acc models.Account
-		err := rows.Scan(
-			&acc.ID, &acc.Auth, &acc.TeamID, &acc.LinuxdoID,
-			&acc.IsActive, &acc.CreatedAt, &acc.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		accounts = append(accounts, acc)
-	}
+func (postgresDialect) secondsSince(expr string) string {
+	return "EXTRACT(EPOCH FROM NOW() - " + expr + ")"
+}
 
-	return accounts, nil
+func (postgresDialect) day(expr string) string {
+	return "TO_CHAR(" + expr + ", 'YYYY-MM-DD')"
 }
+
+func (postgresDialect) bindTime(t time.Time) time.Time { return t }
@@ -0,0 +1,70 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+	"cosine/database/storetest"
+)
+
+// postgresDSNEnv 指定测试用的 Postgres 连接串，未设置时跳过 Postgres 测试
+const postgresDSNEnv = "COSINE_TEST_POSTGRES_DSN"
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skip(postgresDSNEnv + " is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	n := 0
+	storetest.Run(t, func(t *testing.T) database.Store {
+		// 每个测试使用独立的 schema，测试结束后删除
+		n++
+		schema := fmt.Sprintf("storetest_%d_%d", time.Now().UnixNano(), n)
+		if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+				t.Errorf("failed to drop schema %s: %v", schema, err)
+			}
+		})
+
+		s, err := database.OpenPostgres(&config.DatabaseConfig{
+			DSN:            withSearchPath(dsn, schema),
+			ConnectTimeout: 5 * time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.MigrateUp(context.Background()); err != nil {
+			s.Close()
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// withSearchPath 让 dsn 的连接只使用 schema，URL 和 key=value 两种格式都支持
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
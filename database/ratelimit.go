@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// UpdateRateLimitBucket reads and rewrites a token bucket inside one transaction,
+// so instances sharing the database see a consistent bucket. A new bucket
+// starts full with burst tokens. update receives the stored token count and
+// the time since it was written, and returns the count to store.
+func (s *sqlStore) UpdateRateLimitBucket(key string, burst int, update func(tokens float64, elapsed time.Duration) float64) error {
+	defer observeOperation(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO rate_limit_buckets (key, tokens, updated_at)
+		VALUES ($1, $2, `+s.d.now()+`)
+		ON CONFLICT (key) DO NOTHING
+	`, key, burst)
+	if err != nil {
+		return err
+	}
+
+	var tokens, seconds float64
+	err = tx.QueryRow(`
+		SELECT tokens, `+s.d.secondsSince("updated_at")+`
+		FROM rate_limit_buckets
+		WHERE key = $1
+		`+s.d.forUpdate(), key).Scan(&tokens, &seconds)
+	if err != nil {
+		return err
+	}
+
+	tokens = update(tokens, time.Duration(seconds*float64(time.Second)))
+
+	_, err = tx.Exec(`
+		UPDATE rate_limit_buckets
+		SET tokens = $2, updated_at = `+s.d.now()+`
+		WHERE key = $1
+	`, key, tokens)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddRateLimitCounter adds delta to a counter and returns the new value
+func (s *sqlStore) AddRateLimitCounter(key string, delta int64, expiresAt time.Time) (int64, error) {
+	var value int64
+	err := s.db.QueryRow(`
+		INSERT INTO rate_limit_counters (key, value, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET value = rate_limit_counters.value + EXCLUDED.value, expires_at = EXCLUDED.expires_at
+		RETURNING value
+	`, key, delta, s.d.bindTime(expiresAt)).Scan(&value)
+	return value, err
+}
+
+// GetRateLimitCounter returns the value of a counter, 0 if it does not exist
+func (s *sqlStore) GetRateLimitCounter(key string) (int64, error) {
+	var value int64
+	err := s.db.QueryRow(`SELECT value FROM rate_limit_counters WHERE key = $1`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return value, err
+}
+
+// AcquireRateLimitSlot takes one of limit concurrency slots for key. Slots
+// expire after ttl so a crashed instance cannot hold them forever.
+func (s *sqlStore) AcquireRateLimitSlot(key string, limit int, ttl time.Duration) (int64, bool, error) {
+	defer observeOperation(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	if err := s.d.lockTx(tx, lockKey(key)); err != nil {
+		return 0, false, err
+	}
+
+	var count int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM rate_limit_slots
+		WHERE key = $1 AND expires_at > `+s.d.now(), key).Scan(&count)
+	if err != nil {
+		return 0, false, err
+	}
+	if count >= limit {
+		return 0, false, nil
+	}
+
+	var id int64
+	err = tx.QueryRow(`
+		INSERT INTO rate_limit_slots (key, expires_at)
+		VALUES ($1, `+s.d.nowPlusSeconds("$2")+`)
+		RETURNING id
+	`, key, ttl.Seconds()).Scan(&id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, true, tx.Commit()
+}
+
+// ExtendRateLimitSlot keeps a slot taken for ttl from now. It returns
+// sql.ErrNoRows if the slot was already released or purged.
+func (s *sqlStore) ExtendRateLimitSlot(id int64, ttl time.Duration) error {
+	res, err := s.db.Exec(`
+		UPDATE rate_limit_slots SET expires_at = `+s.d.nowPlusSeconds("$2")+`
+		WHERE id = $1
+	`, id, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	return requireAffected(res)
+}
+
+// ReleaseRateLimitSlot frees a slot taken by AcquireRateLimitSlot
+func (s *sqlStore) ReleaseRateLimitSlot(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM rate_limit_slots WHERE id = $1`, id)
+	return err
+}
+
+// PurgeRateLimitState deletes expired counters and slots, and buckets idle for longer than idle
+func (s *sqlStore) PurgeRateLimitState(idle time.Duration) error {
+	if _, err := s.db.Exec(`DELETE FROM rate_limit_counters WHERE expires_at < ` + s.d.now()); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM rate_limit_slots WHERE expires_at < ` + s.d.now()); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < `+s.d.nowPlusSeconds("$1"), -idle.Seconds())
+	return err
+}
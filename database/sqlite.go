@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteNow 是精确到毫秒的当前 UTC 时间，格式与 CURRENT_TIMESTAMP 一致以便按字符串比较
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// OpenSQLite 打开 path 处的 SQLite 数据库，文件不存在时自动创建。
+// 所有访问共用一个连接，写操作因此天然串行，不需要行锁或 advisory lock。
+func OpenSQLite(path string) (Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("database.path is required for the sqlite driver")
+	}
+
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Set("_time_format", "sqlite")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	slog.Info("SQLite database opened", "path", path)
+	return &sqlStore{db: timedDB{db}, d: sqliteDialect{}}, nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) migrationDir() string { return "sqlite" }
+
+func (sqliteDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(), error) {
+	return func() {}, nil
+}
+
+func (sqliteDialect) lockTx(tx *sql.Tx, key int64) error { return nil }
+
+func (sqliteDialect) forUpdate() string { return "" }
+
+func (sqliteDialect) now() string { return sqliteNow }
+
+func (sqliteDialect) nowPlusSeconds(expr string) string {
+	return `strftime('%Y-%m-%d %H:%M:%f', 'now', ` + expr + ` || ' seconds')`
+}
+
+func (sqliteDialect) secondsSince(expr string) string {
+	return "(julianday('now') - julianday(" + expr + ")) * 86400"
+}
+
+func (sqliteDialect) day(expr string) string {
+	return "strftime('%Y-%m-%d', " + expr + ")"
+}
+
+// bindTime 统一存储 UTC 时间，使其与 CURRENT_TIMESTAMP 可以按字符串比较
+func (sqliteDialect) bindTime(t time.Time) time.Time { return t.UTC() }
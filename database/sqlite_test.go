@@ -0,0 +1,24 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"cosine/database"
+	"cosine/database/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		// 每个测试一个独立的内存数据库，OpenSQLite 只用一个连接，数据库随连接关闭而消失
+		s, err := database.OpenSQLite(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.MigrateUp(context.Background()); err != nil {
+			s.Close()
+			t.Fatal(err)
+		}
+		return s
+	})
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"time"
+)
+
+// dialect captures the SQL that differs between the supported databases.
+// Everything else is written once in portable SQL by sqlStore.
+type dialect interface {
+	// migrationDir is the directory under migrations holding the dialect's schema
+	migrationDir() string
+	// lockMigrations serializes migrations of instances sharing the database
+	lockMigrations(ctx context.Context, conn *sql.Conn) (unlock func(), err error)
+	// lockTx serializes transactions using the same key until tx ends
+	lockTx(tx *sql.Tx, key int64) error
+	// forUpdate is appended to SELECTs whose rows are rewritten in the same transaction
+	forUpdate() string
+	// now is the current time with sub-second precision
+	now() string
+	// nowPlusSeconds is the current time plus the seconds held by expr
+	nowPlusSeconds(expr string) string
+	// secondsSince is the number of seconds elapsed since the timestamp expr
+	secondsSince(expr string) string
+	// day formats the timestamp expr as YYYY-MM-DD
+	day(expr string) string
+	// bindTime converts a time passed as query argument
+	bindTime(t time.Time) time.Time
+}
+
+// sqlStore implements Store over database/sql for any dialect
+type sqlStore struct {
+	db timedDB
+	d  dialect
+}
+
+func (s *sqlStore) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	defer observeOperation(time.Now())
+	return s.db.PingContext(ctx)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// requireAffected 在没有任何行被修改时返回 sql.ErrNoRows
+func requireAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// rowScanner 同时适配 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// lockKey hashes a string into the key space of dialect.lockTx
+func lockKey(s string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,494 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cosine/config"
+	"cosine/models"
+	"cosine/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("cosine/database")
+
+// Store is the persistence layer behind the package-level functions.
+// Postgres and SQLite implement it; database.driver in config.yaml selects one.
+type Store interface {
+	AccountStore
+	UserStore
+	CreditStore
+	APIKeyStore
+	UsageStore
+	RateLimitStore
+
+	MigrateUp(ctx context.Context) (int, error)
+	MigrateDown(ctx context.Context, steps int) (int, error)
+	GetMigrationStatus(ctx context.Context) ([]MigrationStatus, error)
+	Stats() sql.DBStats
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// AccountStore persists donated Cosine accounts
+type AccountStore interface {
+	GetActiveAccounts() ([]models.Account, error)
+	GetAccountCount() (int, error)
+	CreateAccount(auth, teamID string, linuxdoID int) (*models.Account, error)
+	InsertAccount(acc *models.Account) (*models.Account, error)
+	GetAccountByID(accountID int) (*models.Account, error)
+	GetAccountsByLinuxdoID(linuxdoID int) ([]models.Account, error)
+	ListAccounts(linuxdoID *int) ([]models.Account, error)
+	ActivateAccount(accountID int) error
+	DeactivateAccount(accountID int, by string) error
+	SetAccountPaused(accountID int, paused bool) error
+	UpdateAccountCredential(accountID int, auth, teamID, refreshToken string) error
+	SetAccountRefreshToken(accountID int, refreshToken string) error
+	ReplaceAccountToken(accountID int, oldAuth, auth, refreshToken string) error
+	SetAccountUpstream(accountID int, upstream string) error
+	SetAccountProxy(accountID int, proxy string) error
+	SetAccountWeight(accountID, weight int) error
+	SetAccountTags(accountID int, tags []string) error
+	DeleteAccount(accountID int) error
+	RecordAccountSuccess(accountID int) error
+	RecordAccountFailure(accountID int, reason string) error
+	ListDonations() ([]Donation, error)
+}
+
+// UserStore persists LinuxDo users
+type UserStore interface {
+	CreateOrUpdateLinuxDoUser(linuxDoID int, username, name string, trustLevel int, active, silenced bool) (*LinuxDoUser, error)
+	GetLinuxDoUserByLinuxDoID(linuxDoID int) (*LinuxDoUser, error)
+	ListLinuxDoUsers(query string, limit, offset int) ([]LinuxDoUser, error)
+	SetLinuxDoUserBanned(linuxDoID int, banned bool) error
+}
+
+// CreditStore persists the donation credit ledger
+type CreditStore interface {
+	GrantDonationRewards(requestsPerHour, tokensPerHour float64, maxPeriod time.Duration) (int, error)
+	GetCreditBalance(linuxDoID int) (*CreditBalance, error)
+	ConsumeCredits(linuxDoID int, requests, tokens int64) (*CreditBalance, error)
+	RefundCredits(linuxDoID int, requests, tokens int64) error
+	ListCredits(linuxDoID, limit int) ([]QuotaCredit, error)
+}
+
+// APIKeyStore persists user API keys
+type APIKeyStore interface {
+	CreateAPIKey(linuxDoID int, name, keyHash, keyPrefix string) (*APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*APIKey, error)
+	ListAPIKeys(linuxDoID int) ([]APIKey, error)
+	RevokeAPIKey(id, linuxDoID int) error
+	TouchAPIKey(id int) error
+}
+
+// UsageStore persists the usage ledger
+type UsageStore interface {
+	InsertUsageRecords(records []UsageRecord) error
+	ListUsageRecords(linuxDoID, limit int) ([]UsageRecord, error)
+	AggregateUsage(groupBy string, from, to time.Time, linuxDoID *int) ([]UsageAggregate, error)
+}
+
+// RateLimitStore persists rate limit state shared between instances
+type RateLimitStore interface {
+	UpdateRateLimitBucket(key string, burst int, update func(tokens float64, elapsed time.Duration) float64) error
+	AddRateLimitCounter(key string, delta int64, expiresAt time.Time) (int64, error)
+	GetRateLimitCounter(key string) (int64, error)
+	AcquireRateLimitSlot(key string, limit int, ttl time.Duration) (int64, bool, error)
+	ExtendRateLimitSlot(id int64, ttl time.Duration) error
+	ReleaseRateLimitSlot(id int64) error
+	PurgeRateLimitState(idle time.Duration) error
+}
+
+var (
+	store   Store
+	counter uint64
+	mu      sync.RWMutex
+)
+
+// Init 连接数据库并应用所有未执行的迁移
+func Init(cfg *config.DatabaseConfig) error {
+	if err := Open(cfg); err != nil {
+		return err
+	}
+
+	if _, err := MigrateUp(context.Background()); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	return nil
+}
+
+// Open 只连接数据库，不执行迁移
+func Open(cfg *config.DatabaseConfig) error {
+	s, err := NewStore(cfg)
+	if err != nil {
+		return err
+	}
+	store = s
+	return nil
+}
+
+// NewStore 按 cfg.Driver 创建存储，默认使用 Postgres
+func NewStore(cfg *config.DatabaseConfig) (Store, error) {
+	switch cfg.Driver {
+	case "", "postgres":
+		return OpenPostgres(cfg)
+	case "sqlite":
+		return OpenSQLite(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", cfg.Driver)
+	}
+}
+
+// Default 返回 Init 或 Open 打开的存储
+func Default() Store {
+	return store
+}
+
+// Stats 返回连接池统计
+func Stats() sql.DBStats {
+	return store.Stats()
+}
+
+// Ping 检查数据库连接是否可用
+func Ping(ctx context.Context) error {
+	return store.Ping(ctx)
+}
+
+func Close() {
+	if store != nil {
+		store.Close()
+	}
+}
+
+// MigrateUp 应用所有未执行的迁移，返回应用的数量
+func MigrateUp(ctx context.Context) (int, error) {
+	return store.MigrateUp(ctx)
+}
+
+// MigrateDown 回滚最近 steps 个已应用的迁移，返回回滚的数量
+func MigrateDown(ctx context.Context, steps int) (int, error) {
+	return store.MigrateDown(ctx, steps)
+}
+
+// GetMigrationStatus 列出所有迁移及其应用时间
+func GetMigrationStatus(ctx context.Context) ([]MigrationStatus, error) {
+	return store.GetMigrationStatus(ctx)
+}
+
+// GetActiveAccounts 获取所有可用（活跃且未暂停）账户
+func GetActiveAccounts() ([]models.Account, error) {
+	return store.GetActiveAccounts()
+}
+
+// GetNextAccount 使用 Round-Robin 获取下一个可用账户
+func GetNextAccount(ctx context.Context) (*models.Account, error) {
+	return GetNextAccountWhere(ctx, nil)
+}
+
+// GetNextAccountWhere 在 accept 接受的可用账户中按权重 Round-Robin，accept 为 nil 时接受全部
+func GetNextAccountWhere(ctx context.Context, accept func(*models.Account) bool) (acc *models.Account, err error) {
+	_, span := tracer.Start(ctx, "database.GetNextAccount")
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		} else {
+			span.SetAttributes(attribute.Int("account.id", acc.ID))
+		}
+		span.End()
+	}()
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	accounts, err := store.GetActiveAccounts()
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("accounts.active", len(accounts)))
+	if accept != nil {
+		matched := accounts[:0]
+		for i := range accounts {
+			if accept(&accounts[i]) {
+				matched = append(matched, accounts[i])
+			}
+		}
+		accounts = matched
+		span.SetAttributes(attribute.Int("accounts.matched", len(accounts)))
+	}
+
+	if len(accounts) == 0 {
+		return nil, fmt.Errorf("no active accounts available")
+	}
+
+	// 权重为 n 的账户在每轮中被选中 n 次
+	total := 0
+	for _, acc := range accounts {
+		total += max(acc.Weight, 1)
+	}
+	n := int(atomic.AddUint64(&counter, 1) % uint64(total))
+	for i := range accounts {
+		if n -= max(accounts[i].Weight, 1); n < 0 {
+			return &accounts[i], nil
+		}
+	}
+	return &accounts[len(accounts)-1], nil
+}
+
+// GetAccountCount 获取可用（活跃且未暂停）账户数量
+func GetAccountCount() (int, error) {
+	return store.GetAccountCount()
+}
+
+// CreateAccount 创建新的捐赠账户，linuxdoID 为 0 时创建不属于任何捐赠者的账户
+func CreateAccount(auth, teamID string, linuxdoID int) (*models.Account, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.CreateAccount(auth, teamID, linuxdoID)
+}
+
+// InsertAccount 用一条语句创建带全部设置的账户：凭证、刷新令牌、所有者、权重、
+// 标签、端点、代理和启用状态，不会留下只写了一部分的账户。Weight 为 0 时按 1 处理
+func InsertAccount(acc *models.Account) (*models.Account, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.InsertAccount(acc)
+}
+
+// GetAccountByID 按 ID 获取账户
+func GetAccountByID(accountID int) (*models.Account, error) {
+	return store.GetAccountByID(accountID)
+}
+
+// GetAccountsByLinuxdoID 获取指定用户捐赠的所有账户
+func GetAccountsByLinuxdoID(linuxdoID int) ([]models.Account, error) {
+	return store.GetAccountsByLinuxdoID(linuxdoID)
+}
+
+// ListAccounts 列出所有账户，linuxdoID 不为 nil 时只返回该用户捐赠的账户
+func ListAccounts(linuxdoID *int) ([]models.Account, error) {
+	return store.ListAccounts(linuxdoID)
+}
+
+// ActivateAccount 重新启用账户并清零失败次数
+func ActivateAccount(accountID int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.ActivateAccount(accountID)
+}
+
+// DeactivateAccount 将账户标记为不活跃，by 记录停用来源（models.DisabledBy*）
+func DeactivateAccount(accountID int, by string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.DeactivateAccount(accountID, by)
+}
+
+// SetAccountPaused 暂停或恢复账户，暂停的账户不参与轮询
+func SetAccountPaused(accountID int, paused bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountPaused(accountID, paused)
+}
+
+// UpdateAccountCredential 替换账户凭证和刷新令牌并清零失败次数，teamID 为空时保留原值。
+// 只有被上游拒绝而停用的账户会重新启用，管理员停用的账户保持停用
+func UpdateAccountCredential(accountID int, auth, teamID, refreshToken string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.UpdateAccountCredential(accountID, auth, teamID, refreshToken)
+}
+
+// SetAccountRefreshToken 设置用于续期会话令牌的刷新令牌，为空时不再自动续期
+func SetAccountRefreshToken(accountID int, refreshToken string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountRefreshToken(accountID, refreshToken)
+}
+
+// ReplaceAccountToken 原子地将账户凭证从 oldAuth 换成续期后的凭证，
+// 凭证已被其他请求更新或账户不存在时返回 sql.ErrNoRows
+func ReplaceAccountToken(accountID int, oldAuth, auth, refreshToken string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.ReplaceAccountToken(accountID, oldAuth, auth, refreshToken)
+}
+
+// SetAccountUpstream 将账户固定到指定的上游端点，为空时可使用任意端点
+func SetAccountUpstream(accountID int, upstream string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountUpstream(accountID, upstream)
+}
+
+// SetAccountProxy 设置账户访问上游使用的代理，为空时使用端点或全局代理
+func SetAccountProxy(accountID int, proxy string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountProxy(accountID, proxy)
+}
+
+// SetAccountWeight 设置账户在轮询中的权重
+func SetAccountWeight(accountID, weight int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountWeight(accountID, weight)
+}
+
+// SetAccountTags 替换账户的标签
+func SetAccountTags(accountID int, tags []string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.SetAccountTags(accountID, tags)
+}
+
+// DeleteAccount 删除账户
+func DeleteAccount(accountID int) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return store.DeleteAccount(accountID)
+}
+
+// RecordAccountSuccess 记录账户的一次成功调用并清零连续失败次数
+func RecordAccountSuccess(accountID int) error {
+	return store.RecordAccountSuccess(accountID)
+}
+
+// RecordAccountFailure 记录账户的一次失败调用
+func RecordAccountFailure(accountID int, reason string) error {
+	return store.RecordAccountFailure(accountID, reason)
+}
+
+// ListDonations 按捐赠者汇总账户，按捐赠数量降序
+func ListDonations() ([]Donation, error) {
+	return store.ListDonations()
+}
+
+// CreateOrUpdateLinuxDoUser creates a new user or updates existing one based on linuxdo_id
+func CreateOrUpdateLinuxDoUser(linuxDoID int, username, name string, trustLevel int, active, silenced bool) (*LinuxDoUser, error) {
+	return store.CreateOrUpdateLinuxDoUser(linuxDoID, username, name, trustLevel, active, silenced)
+}
+
+// GetLinuxDoUserByLinuxDoID retrieves a user by their LinuxDo ID
+func GetLinuxDoUserByLinuxDoID(linuxDoID int) (*LinuxDoUser, error) {
+	return store.GetLinuxDoUserByLinuxDoID(linuxDoID)
+}
+
+// ListLinuxDoUsers returns users whose username or name contains query, newest first
+func ListLinuxDoUsers(query string, limit, offset int) ([]LinuxDoUser, error) {
+	return store.ListLinuxDoUsers(query, limit, offset)
+}
+
+// SetLinuxDoUserBanned bans or unbans a user
+func SetLinuxDoUserBanned(linuxDoID int, banned bool) error {
+	return store.SetLinuxDoUserBanned(linuxDoID, banned)
+}
+
+// GrantDonationRewards credits donors for the time their accounts stayed healthy
+// since the previous run and returns the number of accounts that earned credits
+func GrantDonationRewards(requestsPerHour, tokensPerHour float64, maxPeriod time.Duration) (int, error) {
+	return store.GrantDonationRewards(requestsPerHour, tokensPerHour, maxPeriod)
+}
+
+// GetCreditBalance returns the remaining credits of a user
+func GetCreditBalance(linuxDoID int) (*CreditBalance, error) {
+	return store.GetCreditBalance(linuxDoID)
+}
+
+// ConsumeCredits deducts up to the given amounts from a user's balance and
+// returns what was actually deducted
+func ConsumeCredits(linuxDoID int, requests, tokens int64) (*CreditBalance, error) {
+	return store.ConsumeCredits(linuxDoID, requests, tokens)
+}
+
+// RefundCredits gives back credits consumed by a request that failed
+func RefundCredits(linuxDoID int, requests, tokens int64) error {
+	return store.RefundCredits(linuxDoID, requests, tokens)
+}
+
+// ListCredits returns the most recent ledger entries of a user
+func ListCredits(linuxDoID, limit int) ([]QuotaCredit, error) {
+	return store.ListCredits(linuxDoID, limit)
+}
+
+// CreateAPIKey stores a new API key for a user
+func CreateAPIKey(linuxDoID int, name, keyHash, keyPrefix string) (*APIKey, error) {
+	return store.CreateAPIKey(linuxDoID, name, keyHash, keyPrefix)
+}
+
+// GetAPIKeyByHash looks up an unrevoked API key by the hash of its value
+func GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	return store.GetAPIKeyByHash(keyHash)
+}
+
+// ListAPIKeys returns all API keys of a user, newest first
+func ListAPIKeys(linuxDoID int) ([]APIKey, error) {
+	return store.ListAPIKeys(linuxDoID)
+}
+
+// RevokeAPIKey revokes one of a user's API keys
+func RevokeAPIKey(id, linuxDoID int) error {
+	return store.RevokeAPIKey(id, linuxDoID)
+}
+
+// TouchAPIKey records that an API key was just used
+func TouchAPIKey(id int) error {
+	return store.TouchAPIKey(id)
+}
+
+// InsertUsageRecords writes a batch of usage records in one transaction
+func InsertUsageRecords(records []UsageRecord) error {
+	return store.InsertUsageRecords(records)
+}
+
+// ListUsageRecords returns the most recent usage records of a user
+func ListUsageRecords(linuxDoID, limit int) ([]UsageRecord, error) {
+	return store.ListUsageRecords(linuxDoID, limit)
+}
+
+// AggregateUsage sums usage between from and to grouped by day, model, account
+// or user. A non-nil linuxDoID restricts the result to that user.
+func AggregateUsage(groupBy string, from, to time.Time, linuxDoID *int) ([]UsageAggregate, error) {
+	return store.AggregateUsage(groupBy, from, to, linuxDoID)
+}
+
+// UpdateRateLimitBucket reads and rewrites a token bucket inside one transaction
+func UpdateRateLimitBucket(key string, burst int, update func(tokens float64, elapsed time.Duration) float64) error {
+	return store.UpdateRateLimitBucket(key, burst, update)
+}
+
+// AddRateLimitCounter adds delta to a counter and returns the new value
+func AddRateLimitCounter(key string, delta int64, expiresAt time.Time) (int64, error) {
+	return store.AddRateLimitCounter(key, delta, expiresAt)
+}
+
+// GetRateLimitCounter returns the value of a counter, 0 if it does not exist
+func GetRateLimitCounter(key string) (int64, error) {
+	return store.GetRateLimitCounter(key)
+}
+
+// AcquireRateLimitSlot takes one of limit concurrency slots for key
+func AcquireRateLimitSlot(key string, limit int, ttl time.Duration) (int64, bool, error) {
+	return store.AcquireRateLimitSlot(key, limit, ttl)
+}
+
+// ExtendRateLimitSlot keeps a slot taken for ttl from now
+func ExtendRateLimitSlot(id int64, ttl time.Duration) error {
+	return store.ExtendRateLimitSlot(id, ttl)
+}
+
+// ReleaseRateLimitSlot frees a slot taken by AcquireRateLimitSlot
+func ReleaseRateLimitSlot(id int64) error {
+	return store.ReleaseRateLimitSlot(id)
+}
+
+// PurgeRateLimitState deletes expired counters and slots, and buckets idle for longer than idle
+func PurgeRateLimitState(idle time.Duration) error {
+	return store.PurgeRateLimitState(idle)
+}
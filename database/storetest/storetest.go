@@ -0,0 +1,571 @@
+// Package storetest is a conformance suite every database.Store implementation
+// must pass. A backend runs it from its own test with a factory returning an
+// empty, migrated store:
+//
+//	func TestSQLiteStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) database.Store {
+//			s, err := database.OpenSQLite(":memory:")
+//			...
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"cosine/database"
+	"cosine/models"
+)
+
+// Run runs every conformance test against stores created by newStore. Each
+// test gets its own store, which is closed when the test ends.
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s database.Store)
+	}{
+		{"Migrations", testMigrations},
+		{"Accounts", testAccounts},
+		{"AccountHealth", testAccountHealth},
+		{"Donations", testDonations},
+		{"Users", testUsers},
+		{"Credits", testCredits},
+		{"APIKeys", testAPIKeys},
+		{"Usage", testUsage},
+		{"RateLimitBuckets", testRateLimitBuckets},
+		{"RateLimitCounters", testRateLimitCounters},
+		{"RateLimitSlots", testRateLimitSlots},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			t.Cleanup(func() { s.Close() })
+			defer func() {
+				if r := recover(); r != nil {
+					f, ok := r.(failure)
+					if !ok {
+						panic(r)
+					}
+					t.Fatal(f.err)
+				}
+			}()
+			tt.fn(t, s)
+		})
+	}
+}
+
+// failure carries an unexpected store error from must to Run
+type failure struct{ err error }
+
+// must returns v, failing the running test if err is not nil
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(failure{err})
+	}
+	return v
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testMigrations(t *testing.T, s database.Store) {
+	ctx := context.Background()
+
+	// The factory already migrated the store
+	if n := must(s.MigrateUp(ctx)); n != 0 {
+		t.Fatalf("MigrateUp applied %d migrations on a migrated store", n)
+	}
+
+	statuses := must(s.GetMigrationStatus(ctx))
+	if len(statuses) == 0 {
+		t.Fatal("no migrations reported")
+	}
+	for _, st := range statuses {
+		if st.AppliedAt == nil {
+			t.Fatalf("migration %04d_%s is not applied", st.Version, st.Name)
+		}
+	}
+
+	check(t, s.Ping(ctx))
+}
+
+func testAccounts(t *testing.T, s database.Store) {
+	a := must(s.CreateAccount("auth-a", "team-a", 1))
+	b := must(s.CreateAccount("auth-b", "team-b", 2))
+	if a.Auth != "auth-a" || a.TeamID != "team-a" || a.LinuxdoID == nil || *a.LinuxdoID != 1 {
+		t.Fatalf("unexpected account %+v", a)
+	}
+	if !a.IsActive || a.Paused || a.CreatedAt.IsZero() {
+		t.Fatalf("new account should be active and unpaused: %+v", a)
+	}
+	pool := must(s.CreateAccount("auth-p", "team-p", 0))
+	if pool.LinuxdoID != nil {
+		t.Fatalf("account created without donor has linuxdo_id %d", *pool.LinuxdoID)
+	}
+	check(t, s.DeleteAccount(pool.ID))
+
+	got := must(s.GetAccountByID(a.ID))
+	if got.ID != a.ID || got.Auth != a.Auth {
+		t.Fatalf("GetAccountByID = %+v, want %+v", got, a)
+	}
+	if _, err := s.GetAccountByID(b.ID + 100); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetAccountByID of missing account: got %v, want sql.ErrNoRows", err)
+	}
+
+	if n := must(s.GetAccountCount()); n != 2 {
+		t.Fatalf("GetAccountCount = %d, want 2", n)
+	}
+
+	check(t, s.SetAccountPaused(b.ID, true))
+	active := must(s.GetActiveAccounts())
+	if len(active) != 1 || active[0].ID != a.ID {
+		t.Fatalf("paused account should not be active: %+v", active)
+	}
+	check(t, s.SetAccountPaused(b.ID, false))
+
+	check(t, s.DeactivateAccount(a.ID, models.DisabledByAdmin))
+	if n := must(s.GetAccountCount()); n != 1 {
+		t.Fatalf("GetAccountCount after deactivate = %d, want 1", n)
+	}
+	check(t, s.ActivateAccount(a.ID))
+	if n := must(s.GetAccountCount()); n != 2 {
+		t.Fatalf("GetAccountCount after activate = %d, want 2", n)
+	}
+
+	check(t, s.UpdateAccountCredential(a.ID, "auth-a2", "", "refresh-a2"))
+	got = must(s.GetAccountByID(a.ID))
+	if got.Auth != "auth-a2" || got.TeamID != "team-a" || got.RefreshToken != "refresh-a2" {
+		t.Fatalf("empty team id should keep the old one: %+v", got)
+	}
+	check(t, s.UpdateAccountCredential(a.ID, "auth-a3", "team-c", ""))
+	if got = must(s.GetAccountByID(a.ID)); got.TeamID != "team-c" || got.RefreshToken != "" {
+		t.Fatalf("team id or refresh token not updated: %+v", got)
+	}
+
+	// A new credential revives an account the upstream rejected, not one an
+	// admin disabled
+	check(t, s.DeactivateAccount(a.ID, models.DisabledByUpstream))
+	check(t, s.UpdateAccountCredential(a.ID, "auth-a3", "", ""))
+	if got = must(s.GetAccountByID(a.ID)); !got.IsActive || got.DisabledBy != "" {
+		t.Fatalf("rotation should re-enable an upstream-disabled account: %+v", got)
+	}
+	check(t, s.DeactivateAccount(a.ID, models.DisabledByAdmin))
+	check(t, s.UpdateAccountCredential(a.ID, "auth-a3", "", ""))
+	if got = must(s.GetAccountByID(a.ID)); got.IsActive || got.DisabledBy != models.DisabledByAdmin {
+		t.Fatalf("rotation should keep an admin-disabled account disabled: %+v", got)
+	}
+	check(t, s.ActivateAccount(a.ID))
+	if got = must(s.GetAccountByID(a.ID)); !got.IsActive || got.DisabledBy != "" {
+		t.Fatalf("ActivateAccount should clear disabled_by: %+v", got)
+	}
+
+	// A renewal applies only while nobody else replaced the token
+	check(t, s.SetAccountRefreshToken(a.ID, "refresh-a3"))
+	check(t, s.ReplaceAccountToken(a.ID, "auth-a3", "auth-a4", "refresh-a4"))
+	if got = must(s.GetAccountByID(a.ID)); got.Auth != "auth-a4" || got.RefreshToken != "refresh-a4" {
+		t.Fatalf("token not replaced: %+v", got)
+	}
+	if err := s.ReplaceAccountToken(a.ID, "auth-a3", "auth-a5", "refresh-a5"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ReplaceAccountToken with a stale token: got %v, want sql.ErrNoRows", err)
+	}
+	if got = must(s.GetAccountByID(a.ID)); got.Auth != "auth-a4" {
+		t.Fatalf("stale replace overwrote the token: %+v", got)
+	}
+
+	if got.Upstream != "" {
+		t.Fatalf("new account should not be pinned to an upstream: %+v", got)
+	}
+	check(t, s.SetAccountUpstream(a.ID, "staging"))
+	if got = must(s.GetAccountByID(a.ID)); got.Upstream != "staging" {
+		t.Fatalf("upstream not updated: %+v", got)
+	}
+	if err := s.SetAccountUpstream(b.ID+100, "staging"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("SetAccountUpstream of missing account: got %v, want sql.ErrNoRows", err)
+	}
+	check(t, s.SetAccountProxy(a.ID, "socks5://127.0.0.1:1080"))
+	if got = must(s.GetAccountByID(a.ID)); got.Proxy != "socks5://127.0.0.1:1080" {
+		t.Fatalf("proxy not updated: %+v", got)
+	}
+
+	if got.Weight != 1 || len(got.Tags) != 0 {
+		t.Fatalf("new account should have weight 1 and no tags: %+v", got)
+	}
+	check(t, s.SetAccountWeight(a.ID, 3))
+	check(t, s.SetAccountTags(a.ID, []string{"premium", "team-a"}))
+	if got = must(s.GetAccountByID(a.ID)); got.Weight != 3 || !slices.Equal(got.Tags, []string{"premium", "team-a"}) {
+		t.Fatalf("weight and tags not updated: %+v", got)
+	}
+	check(t, s.SetAccountTags(a.ID, nil))
+	if got = must(s.GetAccountByID(a.ID)); len(got.Tags) != 0 {
+		t.Fatalf("tags not cleared: %+v", got)
+	}
+
+	all := must(s.ListAccounts(nil))
+	if len(all) != 2 || all[0].ID != a.ID || all[1].ID != b.ID {
+		t.Fatalf("ListAccounts(nil) = %+v", all)
+	}
+	linuxdoID := 2
+	if mine := must(s.ListAccounts(&linuxdoID)); len(mine) != 1 || mine[0].ID != b.ID {
+		t.Fatalf("ListAccounts(2) = %+v", mine)
+	}
+	if mine := must(s.GetAccountsByLinuxdoID(1)); len(mine) != 1 || mine[0].ID != a.ID {
+		t.Fatalf("GetAccountsByLinuxdoID(1) = %+v", mine)
+	}
+
+	// InsertAccount stores every setting at once
+	owner := 3
+	full := must(s.InsertAccount(&models.Account{
+		Auth: "auth-full", TeamID: "team-full", LinuxdoID: &owner, DisabledBy: models.DisabledByAdmin,
+		RefreshToken: "refresh-full", Weight: 2, Tags: []string{"team-b"}, Upstream: "staging", Proxy: "http://proxy:8080",
+	}))
+	got = must(s.GetAccountByID(full.ID))
+	if got.Auth != "auth-full" || got.TeamID != "team-full" || got.LinuxdoID == nil || *got.LinuxdoID != owner ||
+		got.IsActive || got.DisabledBy != models.DisabledByAdmin || got.RefreshToken != "refresh-full" || got.Weight != 2 ||
+		!slices.Equal(got.Tags, []string{"team-b"}) || got.Upstream != "staging" || got.Proxy != "http://proxy:8080" {
+		t.Fatalf("InsertAccount did not store every field: %+v", got)
+	}
+	if n := must(s.GetAccountCount()); n != 2 {
+		t.Fatalf("disabled inserted account counted as active: %d", n)
+	}
+	if got = must(s.InsertAccount(&models.Account{Auth: "auth-pool", IsActive: true})); got.Weight != 1 || got.LinuxdoID != nil {
+		t.Fatalf("InsertAccount defaults: %+v", got)
+	}
+	check(t, s.DeleteAccount(got.ID))
+	check(t, s.DeleteAccount(full.ID))
+
+	check(t, s.DeleteAccount(b.ID))
+	missing := b.ID
+	for name, err := range map[string]error{
+		"DeleteAccount":           s.DeleteAccount(missing),
+		"ActivateAccount":         s.ActivateAccount(missing),
+		"SetAccountPaused":        s.SetAccountPaused(missing, true),
+		"UpdateAccountCredential": s.UpdateAccountCredential(missing, "x", "", ""),
+		"SetAccountRefreshToken":  s.SetAccountRefreshToken(missing, "x"),
+		"ReplaceAccountToken":     s.ReplaceAccountToken(missing, "x", "y", ""),
+	} {
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("%s of missing account: got %v, want sql.ErrNoRows", name, err)
+		}
+	}
+}
+
+func testAccountHealth(t *testing.T, s database.Store) {
+	a := must(s.CreateAccount("auth", "team", 1))
+
+	check(t, s.RecordAccountFailure(a.ID, "upstream status 500"))
+	check(t, s.RecordAccountFailure(a.ID, "upstream status 502"))
+	got := must(s.GetAccountByID(a.ID))
+	if got.FailCount != 2 || got.LastError != "upstream status 502" || got.LastErrorAt == nil {
+		t.Fatalf("failures not recorded: %+v", got)
+	}
+	if got.Health() != "degraded" {
+		t.Fatalf("Health = %q, want degraded", got.Health())
+	}
+
+	check(t, s.RecordAccountSuccess(a.ID))
+	got = must(s.GetAccountByID(a.ID))
+	if got.FailCount != 0 || got.RequestCount != 1 || got.LastUsedAt == nil {
+		t.Fatalf("success not recorded: %+v", got)
+	}
+	if got.Health() != "healthy" {
+		t.Fatalf("Health = %q, want healthy", got.Health())
+	}
+}
+
+func testDonations(t *testing.T, s database.Store) {
+	must(s.CreateOrUpdateLinuxDoUser(1, "alice", "", 2, true, false))
+	must(s.CreateAccount("a1", "t", 1))
+	must(s.CreateAccount("b1", "t", 2))
+	b2 := must(s.CreateAccount("b2", "t", 2))
+	check(t, s.DeactivateAccount(b2.ID, models.DisabledByAdmin))
+
+	donations := must(s.ListDonations())
+	if len(donations) != 2 {
+		t.Fatalf("ListDonations = %+v", donations)
+	}
+	if d := donations[0]; d.LinuxDoID != 2 || d.Total != 2 || d.Active != 1 || len(d.AccountIDs) != 2 {
+		t.Fatalf("largest donor first: %+v", d)
+	}
+	if d := donations[1]; d.LinuxDoID != 1 || d.Username != "alice" || d.Total != 1 {
+		t.Fatalf("unexpected donation %+v", d)
+	}
+}
+
+func testUsers(t *testing.T, s database.Store) {
+	if _, err := s.GetLinuxDoUserByLinuxDoID(1); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetLinuxDoUserByLinuxDoID of missing user: got %v, want sql.ErrNoRows", err)
+	}
+
+	u := must(s.CreateOrUpdateLinuxDoUser(1, "alice", "Alice", 1, true, false))
+	if u.LinuxDoID != 1 || u.Username != "alice" || u.Name != "Alice" || u.TrustLevel != 1 || !u.Active || u.Banned {
+		t.Fatalf("unexpected user %+v", u)
+	}
+
+	u2 := must(s.CreateOrUpdateLinuxDoUser(1, "alice2", "", 3, true, true))
+	if u2.ID != u.ID || u2.Username != "alice2" || u2.TrustLevel != 3 || !u2.Silenced {
+		t.Fatalf("user not updated in place: %+v", u2)
+	}
+	must(s.CreateOrUpdateLinuxDoUser(2, "bob", "Bobby", 0, true, false))
+
+	if users := must(s.ListLinuxDoUsers("", 10, 0)); len(users) != 2 || users[0].LinuxDoID != 2 {
+		t.Fatalf("ListLinuxDoUsers should list newest first: %+v", users)
+	}
+	if users := must(s.ListLinuxDoUsers("BOB", 10, 0)); len(users) != 1 || users[0].LinuxDoID != 2 {
+		t.Fatalf("search should be case-insensitive and match name or username: %+v", users)
+	}
+	if users := must(s.ListLinuxDoUsers("", 1, 1)); len(users) != 1 || users[0].LinuxDoID != 1 {
+		t.Fatalf("ListLinuxDoUsers should page: %+v", users)
+	}
+
+	check(t, s.SetLinuxDoUserBanned(1, true))
+	if u := must(s.GetLinuxDoUserByLinuxDoID(1)); !u.Banned {
+		t.Fatal("user not banned")
+	}
+	if err := s.SetLinuxDoUserBanned(3, true); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("banning missing user: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testCredits(t *testing.T, s database.Store) {
+	must(s.CreateAccount("a", "t", 1))
+	broken := must(s.CreateAccount("b", "t", 2))
+	check(t, s.RecordAccountFailure(broken.ID, "upstream status 500"))
+
+	// The accounts are only moments old, so the rates are high enough to earn
+	// something within milliseconds, and the period is capped at one minute.
+	// The broken account earns for the moment it was healthy at most.
+	const perHour = 3.6e12
+	time.Sleep(10 * time.Millisecond)
+	must(s.GrantDonationRewards(perHour, perHour, time.Minute))
+	balance := must(s.GetCreditBalance(1))
+	if balance.Requests <= 0 || balance.Requests > perHour/60 || balance.Tokens != balance.Requests {
+		t.Fatalf("credits do not match the time the account was healthy: %+v", balance)
+	}
+	brokenBalance := must(s.GetCreditBalance(2))
+
+	// Time spent unhealthy since the previous run earns nothing
+	time.Sleep(10 * time.Millisecond)
+	if n := must(s.GrantDonationRewards(perHour, perHour, time.Minute)); n != 1 {
+		t.Fatalf("GrantDonationRewards credited %d accounts, want 1", n)
+	}
+	if b := must(s.GetCreditBalance(2)); *b != *brokenBalance {
+		t.Fatalf("unhealthy account earned credits: %+v, had %+v", b, brokenBalance)
+	}
+
+	// A recovered account earns again, but only for the time since it recovered
+	check(t, s.RecordAccountSuccess(broken.ID))
+	time.Sleep(10 * time.Millisecond)
+	check(t, s.SetAccountPaused(broken.ID, true))
+	time.Sleep(50 * time.Millisecond)
+	must(s.GrantDonationRewards(perHour, perHour, time.Minute))
+	earned := must(s.GetCreditBalance(2)).Requests - brokenBalance.Requests
+	const perMillisecond = perHour / 3600 / 1000
+	if earned < 5*perMillisecond || earned >= 50*perMillisecond {
+		t.Fatalf("recovered account earned %d, want the credits of about 10ms", earned)
+	}
+	balance = must(s.GetCreditBalance(1))
+
+	// Consuming is clamped to the balance
+	base := *balance
+	used := must(s.ConsumeCredits(1, base.Requests+5, 0))
+	if used.Requests != base.Requests || used.Tokens != 0 {
+		t.Fatalf("ConsumeCredits = %+v, want %d requests", used, base.Requests)
+	}
+	if b := must(s.GetCreditBalance(1)); b.Requests != 0 || b.Tokens != base.Tokens {
+		t.Fatalf("balance after consuming = %+v", b)
+	}
+	if used := must(s.ConsumeCredits(3, 1, 1)); used.Requests != 0 || used.Tokens != 0 {
+		t.Fatalf("user without credits consumed %+v", used)
+	}
+
+	check(t, s.RefundCredits(1, 1, 0))
+	if b := must(s.GetCreditBalance(1)); b.Requests != 1 {
+		t.Fatalf("balance after refund = %+v, want 1 request", b)
+	}
+
+	credits := must(s.ListCredits(1, 10))
+	if len(credits) == 0 || credits[len(credits)-1].Reason != database.CreditReasonDonation {
+		t.Fatalf("ListCredits should end with the donation reward: %+v", credits)
+	}
+	if credits[0].Reason != database.CreditReasonRefund || (base.Requests > 0 && credits[1].Reason != database.CreditReasonUsage) {
+		t.Fatalf("ListCredits should list newest first: %+v", credits)
+	}
+	for _, c := range credits {
+		if c.LinuxDoID != 1 || c.CreatedAt.IsZero() {
+			t.Fatalf("unexpected ledger entry %+v", c)
+		}
+	}
+}
+
+func testAPIKeys(t *testing.T, s database.Store) {
+	k := must(s.CreateAPIKey(1, "laptop", "hash-1", "sk-cos-abcd"))
+	if k.LinuxDoID != 1 || k.Name != "laptop" || k.KeyPrefix != "sk-cos-abcd" || k.Revoked || k.LastUsedAt != nil {
+		t.Fatalf("unexpected key %+v", k)
+	}
+	must(s.CreateAPIKey(1, "server", "hash-2", "sk-cos-efgh"))
+
+	got := must(s.GetAPIKeyByHash("hash-1"))
+	if got.ID != k.ID {
+		t.Fatalf("GetAPIKeyByHash = %+v, want %+v", got, k)
+	}
+	check(t, s.TouchAPIKey(k.ID))
+	if got = must(s.GetAPIKeyByHash("hash-1")); got.LastUsedAt == nil {
+		t.Fatal("TouchAPIKey did not set last_used_at")
+	}
+
+	if keys := must(s.ListAPIKeys(1)); len(keys) != 2 || keys[0].Name != "server" {
+		t.Fatalf("ListAPIKeys should list newest first: %+v", keys)
+	}
+
+	if err := s.RevokeAPIKey(k.ID, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("revoking another user's key: got %v, want sql.ErrNoRows", err)
+	}
+	check(t, s.RevokeAPIKey(k.ID, 1))
+	if _, err := s.GetAPIKeyByHash("hash-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("revoked key still found: %v", err)
+	}
+}
+
+func testUsage(t *testing.T, s database.Store) {
+	day1 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	accountID := 7
+	check(t, s.InsertUsageRecords([]database.UsageRecord{
+		{LinuxDoID: 1, Model: "gpt", AccountID: &accountID, PromptTokens: 10, CompletionTokens: 20, LatencyMs: 100, StatusCode: 200, CreatedAt: day1},
+		{LinuxDoID: 1, Model: "gpt", PromptTokens: 0, CompletionTokens: 0, LatencyMs: 300, StatusCode: 502, Stream: true, CreatedAt: day1},
+		{LinuxDoID: 2, Model: "claude", AccountID: &accountID, PromptTokens: 5, CompletionTokens: 5, LatencyMs: 50, StatusCode: 200, CreatedAt: day2},
+		{Model: "gpt", PromptTokens: 1, CompletionTokens: 1, LatencyMs: 10, StatusCode: 200, Passthrough: true, CreatedAt: day2},
+	}))
+
+	records := must(s.ListUsageRecords(1, 10))
+	if len(records) != 2 || records[0].StatusCode != 502 || !records[0].Stream || !records[0].CreatedAt.Equal(day1) {
+		t.Fatalf("ListUsageRecords = %+v", records)
+	}
+
+	from, to := day1.AddDate(0, 0, -1), day2.AddDate(0, 0, 1)
+	byDay := must(s.AggregateUsage("day", from, to, nil))
+	if len(byDay) != 2 || byDay[0].Key != "2026-03-01" || byDay[0].Requests != 2 || byDay[0].Errors != 1 ||
+		byDay[0].PromptTokens != 10 || byDay[0].CompletionTokens != 20 || byDay[0].AvgLatencyMs != 200 {
+		t.Fatalf("AggregateUsage(day) = %+v", byDay)
+	}
+
+	byAccount := must(s.AggregateUsage("account", from, to, nil))
+	if len(byAccount) != 2 || byAccount[0].Key != "" || byAccount[1].Key != "7" || byAccount[1].Requests != 2 {
+		t.Fatalf("AggregateUsage(account) = %+v", byAccount)
+	}
+
+	byUser := must(s.AggregateUsage("user", from, to, nil))
+	if len(byUser) != 3 || byUser[2].Key != "passthrough" || byUser[2].Requests != 1 {
+		t.Fatalf("AggregateUsage(user) = %+v", byUser)
+	}
+	if records := must(s.ListUsageRecords(0, 10)); len(records) != 0 {
+		t.Fatalf("passthrough records listed as a user's: %+v", records)
+	}
+
+	linuxDoID := 2
+	mine := must(s.AggregateUsage("model", from, to, &linuxDoID))
+	if len(mine) != 1 || mine[0].Key != "claude" {
+		t.Fatalf("AggregateUsage(model, user 2) = %+v", mine)
+	}
+
+	if rows := must(s.AggregateUsage("user", day2, to, nil)); len(rows) != 2 || rows[0].Key != "2" {
+		t.Fatalf("AggregateUsage should respect the time range: %+v", rows)
+	}
+	if _, err := s.AggregateUsage("weekday", from, to, nil); err == nil {
+		t.Fatal("unsupported group_by accepted")
+	}
+}
+
+func testRateLimitBuckets(t *testing.T, s database.Store) {
+	var seen []float64
+	take := func(tokens float64, elapsed time.Duration) float64 {
+		if elapsed < 0 || elapsed > time.Minute {
+			t.Errorf("unexpected elapsed %v", elapsed)
+		}
+		seen = append(seen, tokens)
+		return tokens - 1
+	}
+
+	check(t, s.UpdateRateLimitBucket("req:1", 5, take))
+	check(t, s.UpdateRateLimitBucket("req:1", 5, take))
+	if len(seen) != 2 || seen[0] != 5 || seen[1] != 4 {
+		t.Fatalf("bucket should start full and keep the stored tokens: %v", seen)
+	}
+
+	check(t, s.PurgeRateLimitState(time.Hour))
+	check(t, s.UpdateRateLimitBucket("req:1", 5, take))
+	if seen[2] != 3 {
+		t.Fatalf("active bucket was purged: %v", seen)
+	}
+}
+
+func testRateLimitCounters(t *testing.T, s database.Store) {
+	expiresAt := time.Now().Add(time.Hour)
+	if v := must(s.GetRateLimitCounter("tokens:1")); v != 0 {
+		t.Fatalf("missing counter = %d, want 0", v)
+	}
+	must(s.AddRateLimitCounter("tokens:1", 10, expiresAt))
+	if v := must(s.AddRateLimitCounter("tokens:1", -3, expiresAt)); v != 7 {
+		t.Fatalf("AddRateLimitCounter = %d, want 7", v)
+	}
+	if v := must(s.GetRateLimitCounter("tokens:1")); v != 7 {
+		t.Fatalf("GetRateLimitCounter = %d, want 7", v)
+	}
+
+	must(s.AddRateLimitCounter("tokens:2", 1, time.Now().Add(-time.Minute)))
+	check(t, s.PurgeRateLimitState(time.Hour))
+	if v := must(s.GetRateLimitCounter("tokens:2")); v != 0 {
+		t.Fatalf("expired counter survived the purge: %d", v)
+	}
+	if v := must(s.GetRateLimitCounter("tokens:1")); v != 7 {
+		t.Fatalf("live counter was purged: %d", v)
+	}
+}
+
+func testRateLimitSlots(t *testing.T, s database.Store) {
+	id1, ok, err := s.AcquireRateLimitSlot("streams:1", 2, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("first slot: ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := s.AcquireRateLimitSlot("streams:1", 2, time.Minute); err != nil || !ok {
+		t.Fatalf("second slot: ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := s.AcquireRateLimitSlot("streams:1", 2, time.Minute); err != nil || ok {
+		t.Fatalf("third slot should be refused: ok=%v err=%v", ok, err)
+	}
+
+	check(t, s.ReleaseRateLimitSlot(id1))
+	if _, ok, err := s.AcquireRateLimitSlot("streams:1", 2, time.Minute); err != nil || !ok {
+		t.Fatalf("released slot not reusable: ok=%v err=%v", ok, err)
+	}
+
+	// Expired slots no longer count against the limit
+	if _, ok, err := s.AcquireRateLimitSlot("streams:2", 1, -time.Second); err != nil || !ok {
+		t.Fatalf("slot: ok=%v err=%v", ok, err)
+	}
+	id2, ok, err := s.AcquireRateLimitSlot("streams:2", 1, time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expired slot still counted: ok=%v err=%v", ok, err)
+	}
+
+	// An extended slot stays taken past its original expiry
+	check(t, s.ExtendRateLimitSlot(id2, -time.Second))
+	check(t, s.ExtendRateLimitSlot(id2, time.Minute))
+	if _, ok, err := s.AcquireRateLimitSlot("streams:2", 1, time.Minute); err != nil || ok {
+		t.Fatalf("extended slot should still be taken: ok=%v err=%v", ok, err)
+	}
+	check(t, s.ReleaseRateLimitSlot(id2))
+	if err := s.ExtendRateLimitSlot(id2, time.Minute); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ExtendRateLimitSlot of released slot: got %v, want sql.ErrNoRows", err)
+	}
+}
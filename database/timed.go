@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+	"time"
+
+	"cosine/metrics"
+)
+
+// timedDB 记录每条语句的耗时，按执行它的 sqlStore 方法名归类。
+// 事务中的语句不经过它，使用事务的方法用 observeOperation 记录整体耗时。
+type timedDB struct {
+	*sql.DB
+}
+
+func (db timedDB) Exec(query string, args ...any) (sql.Result, error) {
+	defer observe(time.Now(), 2)
+	return db.DB.Exec(query, args...)
+}
+
+func (db timedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	defer observe(time.Now(), 2)
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+func (db timedDB) Query(query string, args ...any) (*sql.Rows, error) {
+	defer observe(time.Now(), 2)
+	return db.DB.Query(query, args...)
+}
+
+func (db timedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	defer observe(time.Now(), 2)
+	return db.DB.QueryContext(ctx, query, args...)
+}
+
+func (db timedDB) QueryRow(query string, args ...any) *sql.Row {
+	defer observe(time.Now(), 2)
+	return db.DB.QueryRow(query, args...)
+}
+
+func (db timedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	defer observe(time.Now(), 2)
+	return db.DB.QueryRowContext(ctx, query, args...)
+}
+
+// observeOperation 记录调用方法自 start 起的耗时，用法为 defer observeOperation(time.Now())
+func observeOperation(start time.Time) {
+	observe(start, 2)
+}
+
+// observe 记录耗时，skip 为 observe 之上被计时方法所在的栈帧层数
+func observe(start time.Time, skip int) {
+	metrics.ObserveQuery(operationName(skip), time.Since(start))
+}
+
+// operationName 返回 operationName 的调用方之上第 skip 层的方法名，如 GetActiveAccounts
+func operationName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return "unknown"
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
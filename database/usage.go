@@ -0,0 +1,147 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// UsageRecord is one chat request as written to the usage ledger
+type UsageRecord struct {
+	ID               int64  `json:"id"`
+	LinuxDoID        int    `json:"linuxdo_id"`
+	APIKeyID         *int   `json:"api_key_id"`
+	Model            string `json:"model"`
+	AccountID        *int   `json:"account_id"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	LatencyMs        int64  `json:"latency_ms"`
+	StatusCode       int    `json:"status_code"`
+	Stream           bool   `json:"stream"`
+	// Passthrough marks requests made with the caller's own Cosine
+	// credential; they have no user or account
+	Passthrough bool      `json:"passthrough"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UsageAggregate sums usage records sharing the same group key
+type UsageAggregate struct {
+	Key              string  `json:"key"`
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+}
+
+// usageGroupKey returns the SQL expression grouping usage records for a
+// supported group_by value
+func (s *sqlStore) usageGroupKey(groupBy string) (string, bool) {
+	switch groupBy {
+	case "day":
+		return s.d.day("created_at"), true
+	case "model":
+		return `model`, true
+	case "account":
+		return `COALESCE(CAST(account_id AS TEXT), '')`, true
+	case "user":
+		return `CASE WHEN passthrough THEN 'passthrough' ELSE CAST(linuxdo_id AS TEXT) END`, true
+	}
+	return "", false
+}
+
+// InsertUsageRecords writes a batch of usage records in one transaction
+func (s *sqlStore) InsertUsageRecords(records []UsageRecord) error {
+	defer observeOperation(time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO usage_records (linuxdo_id, api_key_id, model, account_id,
+			prompt_tokens, completion_tokens, latency_ms, status_code, stream, passthrough, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		_, err := stmt.Exec(r.LinuxDoID, r.APIKeyID, r.Model, r.AccountID,
+			r.PromptTokens, r.CompletionTokens, r.LatencyMs, r.StatusCode, r.Stream, r.Passthrough, s.d.bindTime(r.CreatedAt))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListUsageRecords returns the most recent usage records of a user
+func (s *sqlStore) ListUsageRecords(linuxDoID, limit int) ([]UsageRecord, error) {
+	rows, err := s.db.Query(`
+		SELECT id, linuxdo_id, api_key_id, model, account_id, prompt_tokens,
+			completion_tokens, latency_ms, status_code, stream, passthrough, created_at
+		FROM usage_records
+		WHERE linuxdo_id = $1 AND NOT passthrough
+		ORDER BY id DESC
+		LIMIT $2
+	`, linuxDoID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []UsageRecord
+	for rows.Next() {
+		var r UsageRecord
+		err := rows.Scan(&r.ID, &r.LinuxDoID, &r.APIKeyID, &r.Model, &r.AccountID, &r.PromptTokens,
+			&r.CompletionTokens, &r.LatencyMs, &r.StatusCode, &r.Stream, &r.Passthrough, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
+
+// AggregateUsage sums usage between from and to grouped by day, model, account
+// or user. A non-nil linuxDoID restricts the result to that user.
+func (s *sqlStore) AggregateUsage(groupBy string, from, to time.Time, linuxDoID *int) ([]UsageAggregate, error) {
+	keyExpr, ok := s.usageGroupKey(groupBy)
+	if !ok {
+		return nil, fmt.Errorf("unsupported group_by %q", groupBy)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+keyExpr+` AS key, COUNT(*),
+			COUNT(*) FILTER (WHERE status_code >= 400),
+			COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+			COALESCE(AVG(latency_ms), 0)
+		FROM usage_records
+		WHERE created_at >= $1 AND created_at < $2
+			AND (CAST($3 AS INTEGER) IS NULL OR (linuxdo_id = $3 AND NOT passthrough))
+		GROUP BY key
+		ORDER BY key
+	`, s.d.bindTime(from), s.d.bindTime(to), linuxDoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aggregates []UsageAggregate
+	for rows.Next() {
+		var a UsageAggregate
+		err := rows.Scan(&a.Key, &a.Requests, &a.Errors, &a.PromptTokens, &a.CompletionTokens, &a.AvgLatencyMs)
+		if err != nil {
+			return nil, err
+		}
+		aggregates = append(aggregates, a)
+	}
+
+	return aggregates, rows.Err()
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"cosine/auth"
+	"cosine/bulk"
+	"cosine/config"
+	"cosine/database"
+	"cosine/models"
+	"cosine/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+// accountView is an account as shown through the API, with its derived health state.
+// Proxy is only filled for admins, with any password redacted.
+type accountView struct {
+	models.Account
+	Health string `json:"health"`
+	Proxy  string `json:"proxy,omitempty"`
+}
+
+// AdminListUsersHandler lists and searches users
+// GET /api/admin/users?q=&limit=&offset=
+func AdminListUsersHandler(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	users, err := database.ListLinuxDoUsers(c.Query("q"), limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// AdminBanUserHandler bans a user
+// POST /api/admin/users/:linuxdo_id/ban
+func AdminBanUserHandler(c *gin.Context) {
+	setUserBanned(c, true)
+}
+
+// AdminUnbanUserHandler lifts a ban
+// POST /api/admin/users/:linuxdo_id/unban
+func AdminUnbanUserHandler(c *gin.Context) {
+	setUserBanned(c, false)
+}
+
+func setUserBanned(c *gin.Context, banned bool) {
+	linuxDoID, err := strconv.Atoi(c.Param("linuxdo_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid linuxdo_id"})
+		return
+	}
+
+	err = database.SetLinuxDoUserBanned(linuxDoID, banned)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user: " + err.Error()})
+		return
+	}
+	auth.InvalidateUserStatus(linuxDoID)
+
+	c.JSON(http.StatusOK, gin.H{"linuxdo_id": linuxDoID, "banned": banned})
+}
+
+// AdminListAccountsHandler lists donated accounts with their health state,
+// optionally only those carrying a tag
+// GET /api/admin/accounts?linuxdo_id=&tag=
+func AdminListAccountsHandler(c *gin.Context) {
+	var linuxDoID *int
+	if v := c.Query("linuxdo_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid linuxdo_id"})
+			return
+		}
+		linuxDoID = &id
+	}
+
+	accounts, err := database.ListAccounts(linuxDoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list accounts: " + err.Error()})
+		return
+	}
+
+	tag := c.Query("tag")
+	result := make([]accountView, 0, len(accounts))
+	for _, acc := range accounts {
+		if tag == "" || slices.Contains(acc.Tags, tag) {
+			result = append(result, accountView{Account: acc, Health: acc.Health(), Proxy: redactProxy(acc.Proxy)})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": result})
+}
+
+// AdminActivateAccountHandler re-enables an account
+// POST /api/admin/accounts/:id/activate
+func AdminActivateAccountHandler(c *gin.Context) {
+	if acc, ok := adminAccount(c); ok {
+		updateAccount(c, database.ActivateAccount, acc.ID)
+	}
+}
+
+// AdminDeactivateAccountHandler disables an account
+// POST /api/admin/accounts/:id/deactivate
+func AdminDeactivateAccountHandler(c *gin.Context) {
+	if acc, ok := adminAccount(c); ok {
+		updateAccount(c, func(id int) error {
+			return database.DeactivateAccount(id, models.DisabledByAdmin)
+		}, acc.ID)
+	}
+}
+
+// AdminDeleteAccountHandler removes an account
+// DELETE /api/admin/accounts/:id
+func AdminDeleteAccountHandler(c *gin.Context) {
+	acc, ok := adminAccount(c)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteAccount(acc.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account deleted", "id": acc.ID})
+}
+
+// SetAccountUpstreamRequest pins an account to an upstream endpoint; an empty
+// upstream unpins it
+type SetAccountUpstreamRequest struct {
+	Upstream string `json:"upstream"`
+}
+
+// AdminSetAccountUpstreamHandler pins an account to an upstream endpoint
+// PUT /api/admin/accounts/:id/upstream
+func AdminSetAccountUpstreamHandler(c *gin.Context) {
+	acc, ok := adminAccount(c)
+	if !ok {
+		return
+	}
+
+	var req SetAccountUpstreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if !upstream.Known(req.Upstream) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown upstream: " + req.Upstream})
+		return
+	}
+
+	updateAccount(c, func(id int) error {
+		return database.SetAccountUpstream(id, req.Upstream)
+	}, acc.ID)
+}
+
+// SetAccountProxyRequest sets the egress proxy of an account; an empty proxy
+// falls back to the endpoint or transport proxy
+type SetAccountProxyRequest struct {
+	Proxy string `json:"proxy"`
+}
+
+// AdminSetAccountProxyHandler sets the proxy an account reaches Cosine through
+// PUT /api/admin/accounts/:id/proxy
+func AdminSetAccountProxyHandler(c *gin.Context) {
+	acc, ok := adminAccount(c)
+	if !ok {
+		return
+	}
+
+	var req SetAccountProxyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if err := config.ValidateProxyURL(req.Proxy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updateAccount(c, func(id int) error {
+		return database.SetAccountProxy(id, req.Proxy)
+	}, acc.ID)
+}
+
+// SetAccountTagsRequest replaces the tags of an account, which place it in
+// routing groups; an empty list clears them
+type SetAccountTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// AdminSetAccountTagsHandler sets the tags of an account
+// PUT /api/admin/accounts/:id/tags
+func AdminSetAccountTagsHandler(c *gin.Context) {
+	acc, ok := adminAccount(c)
+	if !ok {
+		return
+	}
+
+	var req SetAccountTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	tags := bulk.NormalizeTags(req.Tags)
+	for _, tag := range tags {
+		if err := config.ValidateTag(tag); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	updateAccount(c, func(id int) error {
+		return database.SetAccountTags(id, tags)
+	}, acc.ID)
+}
+
+// SetAccountWeightRequest sets the share of requests an account gets
+// relative to the other accounts it is selected with
+type SetAccountWeightRequest struct {
+	Weight int `json:"weight"`
+}
+
+// AdminSetAccountWeightHandler sets the weight of an account
+// PUT /api/admin/accounts/:id/weight
+func AdminSetAccountWeightHandler(c *gin.Context) {
+	acc, ok := adminAccount(c)
+	if !ok {
+		return
+	}
+
+	var req SetAccountWeightRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if req.Weight < 1 || req.Weight > bulk.MaxWeight {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("weight must be between 1 and %d", bulk.MaxWeight)})
+		return
+	}
+
+	updateAccount(c, func(id int) error {
+		return database.SetAccountWeight(id, req.Weight)
+	}, acc.ID)
+}
+
+// redactProxy hides the password of a proxy URL
+func redactProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// AdminListUpstreamsHandler shows the upstream endpoints and their health
+// GET /api/admin/upstreams
+func AdminListUpstreamsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"upstreams": upstream.Status()})
+}
+
+// maxImportSize bounds the body of an account import
+const maxImportSize = 10 << 20
+
+// ExportPassphraseHeader carries the passphrase of an encrypted import
+const ExportPassphraseHeader = "X-Export-Passphrase"
+
+// AdminImportAccountsHandler imports accounts from a CSV, JSON or encrypted
+// export sent as the request body, reporting the outcome of every row.
+// Credentials are checked against Cosine unless probe=false.
+// POST /api/admin/accounts/import?format=&dry_run=&probe=
+func AdminImportAccountsHandler(c *gin.Context) {
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read import: " + err.Error()})
+		return
+	}
+
+	records, err := bulk.Decode(data, c.Query("format"), c.GetHeader(ExportPassphraseHeader))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := bulk.Options{
+		DryRun: c.Query("dry_run") == "true",
+		Probe:  c.Query("probe") != "false",
+	}
+	report, err := bulk.Import(c.Request.Context(), records, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import accounts: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// ExportAccountsRequest sets the passphrase the export is encrypted with
+type ExportAccountsRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+// AdminExportAccountsHandler downloads every account, credentials included,
+// encrypted with the given passphrase for import into another deployment
+// POST /api/admin/accounts/export
+func AdminExportAccountsHandler(c *gin.Context) {
+	var req ExportAccountsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	records, err := bulk.Export()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list accounts: " + err.Error()})
+		return
+	}
+	plain, err := json.Marshal(records)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encode accounts: " + err.Error()})
+		return
+	}
+	sealed, err := bulk.Encrypt(plain, req.Passphrase)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := "cosine-accounts-" + time.Now().Format("20060102") + ".json"
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "application/json", sealed)
+}
+
+// adminAccount loads the account in the :id path parameter
+func adminAccount(c *gin.Context) (*models.Account, bool) {
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return nil, false
+	}
+
+	acc, err := database.GetAccountByID(accountID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	return acc, true
+}
+
+// updateAccount applies fn to an account and responds with its new state
+func updateAccount(c *gin.Context, fn func(accountID int) error, accountID int) {
+	if err := fn(accountID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	acc, err := database.GetAccountByID(accountID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"account": accountView{Account: *acc, Health: acc.Health()}})
+}
+
+// AdminListDonationsHandler shows who donated which accounts
+// GET /api/admin/donations
+func AdminListDonationsHandler(c *gin.Context) {
+	donations, err := database.ListDonations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list donations: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"donations": donations})
+}
+
+// AdminReloadConfigHandler reloads the config file and environment, like SIGHUP
+// POST /api/admin/config/reload
+func AdminReloadConfigHandler(c *gin.Context) {
+	result, err := config.Reload()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "config reload failed: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -4,10 +4,12 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
+	"time"
 
 	"cosine/auth"
 	"cosine/config"
 	"cosine/database"
+	"cosine/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,7 +24,7 @@ func generateState() string {
 // LinuxDoAuthURLHandler returns the OAuth authorization URL
 // GET /api/auth/linuxdo/url
 func LinuxDoAuthURLHandler(c *gin.Context) {
-	cfg := config.GlobalConfig
+	cfg := config.Get()
 	if cfg == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "config not loaded"})
 		return
@@ -40,7 +42,7 @@ func LinuxDoAuthURLHandler(c *gin.Context) {
 // LinuxDoCallbackHandler handles the OAuth callback
 // GET /api/auth/linuxdo/callback
 func LinuxDoCallbackHandler(c *gin.Context) {
-	cfg := config.GlobalConfig
+	cfg := config.Get()
 	if cfg == nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "config not loaded"})
 		return
@@ -68,12 +70,19 @@ func LinuxDoCallbackHandler(c *gin.Context) {
 		userInfo.Name,
 		userInfo.TrustLevel,
 		userInfo.Active,
+		userInfo.Silenced,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save user: " + err.Error()})
 		return
 	}
 
+	// Refuse login for accounts the policy does not allow
+	if err := auth.CheckUser(cfg, dbUser); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "login denied: " + err.Error()})
+		return
+	}
+
 	// Generate JWT token
 	token, err := auth.GenerateToken(
 		dbUser.ID,
@@ -81,6 +90,8 @@ func LinuxDoCallbackHandler(c *gin.Context) {
 		dbUser.Name,
 		dbUser.LinuxDoID,
 		dbUser.TrustLevel,
+		time.Now(),
+		cfg.JWT.MaxSessionAge,
 		cfg.JWT.Secret,
 	)
 	if err != nil {
@@ -94,10 +105,65 @@ func LinuxDoCallbackHandler(c *gin.Context) {
 	})
 }
 
-// DonateRequest represents the request body for donating auth credentials
+// RefreshTokenHandler re-checks the user's stored status and issues a new token.
+// The stored status is only as fresh as the last login: a user silenced or
+// deactivated on LinuxDo keeps access until the session reaches
+// jwt.max_session_age and must log in through LinuxDo again.
+// POST /api/auth/refresh
+// Requires: Authorization header with Bearer token
+func RefreshTokenHandler(c *gin.Context) {
+	cfg := config.Get()
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	loginAt := claims.SessionStart()
+	if time.Since(loginAt) > cfg.JWT.MaxSessionAge {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired, log in again"})
+		return
+	}
+
+	dbUser, err := database.GetLinuxDoUserByLinuxDoID(claims.LinuxDoID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+		return
+	}
+
+	if err := auth.CheckUser(cfg, dbUser); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "refresh denied: " + err.Error()})
+		return
+	}
+
+	token, err := auth.GenerateToken(
+		dbUser.ID,
+		dbUser.Username,
+		dbUser.Name,
+		dbUser.LinuxDoID,
+		dbUser.TrustLevel,
+		loginAt,
+		cfg.JWT.MaxSessionAge,
+		cfg.JWT.Secret,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token": token,
+		"user":  dbUser,
+	})
+}
+
+// DonateRequest represents the request body for donating auth credentials.
+// With a RefreshToken the session token is renewed automatically when token
+// refresh is enabled.
 type DonateRequest struct {
-	Auth   string `json:"auth" binding:"required"`
-	TeamID string `json:"team_id" binding:"required"`
+	Auth         string `json:"auth" binding:"required"`
+	TeamID       string `json:"team_id" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // DonateHandler handles account donation
@@ -111,6 +177,11 @@ func DonateHandler(c *gin.Context) {
 		return
 	}
 
+	if err := auth.CheckDonate(config.Get(), claims.LinuxDoTrustLevel); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
 	var req DonateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
@@ -123,8 +194,14 @@ func DonateHandler(c *gin.Context) {
 		return
 	}
 
-	// Create the account with the user's linuxdo_id
-	account, err := database.CreateAccount(req.Auth, req.TeamID, claims.LinuxDoID)
+	// Create the account with the user's linuxdo_id and refresh token in one write
+	account, err := database.InsertAccount(&models.Account{
+		Auth:         req.Auth,
+		TeamID:       req.TeamID,
+		LinuxdoID:    &claims.LinuxDoID,
+		IsActive:     true,
+		RefreshToken: req.RefreshToken,
+	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create account: " + err.Error()})
 		return
@@ -4,18 +4,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net/http"
 	"time"
 
+	"cosine/auth"
+	"cosine/config"
 	"cosine/database"
+	"cosine/logging"
+	"cosine/metrics"
 	"cosine/models"
+	"cosine/ratelimit"
+	"cosine/refresh"
+	"cosine/registry"
+	"cosine/routing"
+	"cosine/tracing"
 	"cosine/upstream"
+	"cosine/usage"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("cosine/handlers")
+
 const maxRetries = 3
 
 func ChatCompletionsHandler(c *gin.Context) {
@@ -25,62 +40,329 @@ func ChatCompletionsHandler(c *gin.Context) {
 		return
 	}
 
+	// 解析模型名称（含别名），未知或已禁用的模型返回 model_not_found
+	model, err := registry.Resolve(req.Model)
+	if err != nil {
+		sendModelNotFound(c, req.Model)
+		return
+	}
+	metrics.SetModel(c, model.ID)
+	logging.With(c, "model", model.ID)
+
+	// 调用方自带 Cosine 凭证的请求直接转发，不经过账户池和配额
+	if cred, ok := auth.GetPassthroughFromContext(c); ok {
+		passthroughChat(c, &req, model, cred)
+		return
+	}
+
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		sendError(c, http.StatusUnauthorized, "invalid_api_key", "unauthorized")
+		return
+	}
+
+	// 请求结束时异步写入用量记录
+	record := database.UsageRecord{
+		LinuxDoID: claims.LinuxDoID,
+		Model:     model.ID,
+		Stream:    req.Stream,
+		CreatedAt: time.Now(),
+	}
+	if id, ok := c.Get("api_key_id"); ok {
+		keyID := id.(int)
+		record.APIKeyID = &keyID
+	}
+	defer func() {
+		record.LatencyMs = time.Since(record.CreatedAt).Milliseconds()
+		record.StatusCode = c.Writer.Status()
+		usage.Record(record)
+	}()
+
+	// 按信任等级检查模型权限和每日配额
+	cfg := config.Get()
+	if err := auth.CheckModel(cfg, claims.LinuxDoTrustLevel, model.ID); err != nil {
+		sendError(c, http.StatusForbidden, "model_not_allowed", err.Error())
+		return
+	}
+	refund, err := auth.ReserveDailyRequest(cfg, claims.LinuxDoID, claims.LinuxDoTrustLevel)
+	if err != nil {
+		sendError(c, http.StatusTooManyRequests, "insufficient_quota", err.Error())
+		return
+	}
+	// 没有可用账户、上游失败等 5xx 不是调用方的问题，退还预占的配额
+	defer func() {
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			refund()
+		}
+	}()
+
+	// 按路由规则确定可用的账户组
+	route := routing.Resolve(&cfg.Routing, routing.Request{
+		LinuxDoID: claims.LinuxDoID,
+		APIKeyID:  record.APIKeyID,
+		Model:     model.ID,
+	})
+	if route.Rule != "" {
+		logging.With(c, "routing_rule", route.Rule)
+	}
+
 	// 转换请求格式
-	cosineReq := convertToCosineRequest(&req)
+	cosineReq := convertToCosineRequest(&req, model)
 
 	// 带重试的请求
 	var resp *http.Response
 	var account *models.Account
-	var err error
+	// 本次请求中失败过的端点，重试时优先换用其他端点
+	var failedEndpoints []string
+
+	ctx := c.Request.Context()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("chat.model", model.ID), attribute.Bool("chat.stream", req.Stream), attribute.String("chat.route", route.Rule))
+
+	// retry 结束本次尝试的 span 并记录重试原因
+	retry := func(span trace.Span, reason string) {
+		metrics.Retry(reason)
+		span.SetAttributes(attribute.String("chat.retry_reason", reason))
+		span.SetStatus(codes.Error, reason)
+		span.End()
+	}
+
+	// renewed 是刚续期了会话令牌的账户，下一次尝试直接用它重试；每个请求最多续期一次
+	var renewed *models.Account
+	tokenRefreshed := false
 
 	for i := 0; i < maxRetries; i++ {
-		account, err = database.GetNextAccount()
+		attemptCtx, span := tracer.Start(ctx, "chat.attempt", trace.WithAttributes(attribute.Int("chat.attempt", i+1)))
+
+		// 只选择路由允许、能提供该模型且固定端点存在的账户，账户组内无可用账户时按规则回退到共享池
+		if renewed != nil {
+			account, err = renewed, nil
+			renewed = nil
+		} else {
+			account, err = database.GetNextAccountWhere(attemptCtx, accountFilter(&route, model))
+		}
+		if err != nil && route.Fallback {
+			shared := routing.Shared(&cfg.Routing)
+			account, err = database.GetNextAccountWhere(attemptCtx, accountFilter(&shared, model))
+		}
 		if err != nil {
+			tracing.RecordError(span, err)
+			span.End()
 			sendError(c, http.StatusServiceUnavailable, "service_unavailable", "no available accounts")
 			return
 		}
+		span.SetAttributes(attribute.Int("account.id", account.ID))
+		logger := logging.FromContext(ctx).With("account_id", account.ID, "attempt", i+1)
 
 		cosineReq.TeamID = account.TeamID
-		client := upstream.NewCosineClient()
-		resp, err = client.SendChatRequest(cosineReq, account.Auth)
+		client, err := upstream.NewCosineClient(account, failedEndpoints...)
+		if err != nil {
+			logger.Warn("No upstream endpoint for account", "error", err)
+			retry(span, "no_endpoint")
+			continue
+		}
+		span.SetAttributes(attribute.String("upstream.endpoint", client.Endpoint()))
+		resp, err = client.SendChatRequest(attemptCtx, cosineReq, account.Auth)
 
 		if err != nil {
-			log.Printf("Request failed for account %d: %v", account.ID, err)
+			logger.Warn("Upstream request failed", "endpoint", client.Endpoint(), "error", err)
+			metrics.ObserveUpstream(account.ID, client.Endpoint(), 0)
+			retry(span, "upstream_error")
+			database.RecordAccountFailure(account.ID, err.Error())
+			failedEndpoints = append(failedEndpoints, client.Endpoint())
 			continue
 		}
+		metrics.ObserveUpstream(account.ID, client.Endpoint(), resp.StatusCode)
 
 		// 检查响应状态码
 		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			log.Printf("Account %d returned %d, deactivating", account.ID, resp.StatusCode)
-			database.DeactivateAccount(account.ID)
 			resp.Body.Close()
+			// 有刷新令牌的账户先续期会话令牌，成功后下一次尝试用更新后的凭证重试同一账户
+			if cfg.TokenRefresh.Enabled && account.RefreshToken != "" && !tokenRefreshed {
+				tokenRefreshed = true
+				refreshed, err := refresh.Refresh(attemptCtx, account, refresh.TriggerUnauthorized)
+				if err == nil {
+					logger.Info("Account token renewed after rejection", "endpoint", client.Endpoint(), "status", resp.StatusCode)
+					retry(span, "token_refreshed")
+					if refreshed.IsActive && !refreshed.Paused {
+						renewed = refreshed
+					}
+					continue
+				}
+				logger.Warn("Failed to renew rejected account token", "error", err)
+			}
+			logger.Warn("Account rejected by upstream, deactivating", "endpoint", client.Endpoint(), "status", resp.StatusCode)
+			retry(span, "unauthorized")
+			database.RecordAccountFailure(account.ID, fmt.Sprintf("upstream status %d", resp.StatusCode))
+			database.DeactivateAccount(account.ID, models.DisabledByUpstream)
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			log.Printf("Upstream returned status %d", resp.StatusCode)
+			logger.Warn("Upstream returned error status", "endpoint", client.Endpoint(), "status", resp.StatusCode)
+			retry(span, "upstream_status")
+			database.RecordAccountFailure(account.ID, fmt.Sprintf("upstream status %d", resp.StatusCode))
+			if resp.StatusCode >= http.StatusInternalServerError {
+				failedEndpoints = append(failedEndpoints, client.Endpoint())
+			}
 			resp.Body.Close()
 			continue
 		}
 
-		// 请求成功
+		// 请求成功，后续日志和访问日志带上最终使用的账户
+		database.RecordAccountSuccess(account.ID)
+		logging.With(c, "account_id", account.ID, "attempt", i+1, "endpoint", client.Endpoint())
+		span.End()
+		break
+	}
+
+	if resp == nil || resp.StatusCode != http.StatusOK {
+		sendError(c, http.StatusBadGateway, "upstream_error", "failed to get response from upstream after retries")
+		return
+	}
+	defer resp.Body.Close()
+	// 只记录最终提供响应的账户，全部失败时不归属任何账户
+	record.AccountID = &account.ID
+
+	var finish *models.CosineFinishEvent
+	if req.Stream {
+		finish = handleStreamResponse(c, resp, model.ID, record.CreatedAt)
+	} else {
+		finish = handleNonStreamResponse(c, resp, model.ID)
+	}
+
+	record.PromptTokens, record.CompletionTokens = finishUsage(finish)
+	tokens := record.PromptTokens + record.CompletionTokens
+	auth.RecordDailyTokens(cfg, claims.LinuxDoID, claims.LinuxDoTrustLevel, tokens)
+	ratelimit.SetUsage(c, tokens)
+}
+
+// passthroughChat 用调用方自带的 Cosine 凭证转发请求。网络错误和 5xx 时换用其他端点重试，
+// 凭证被拒绝时直接返回 401，其他 4xx（如 429、400）原样返回状态码
+func passthroughChat(c *gin.Context, req *models.OpenAIChatRequest, model *registry.Model, cred *auth.Credential) {
+	// 用量记录不属于任何用户和账户，只标记为 passthrough
+	record := database.UsageRecord{
+		Model:       model.ID,
+		Stream:      req.Stream,
+		Passthrough: true,
+		CreatedAt:   time.Now(),
+	}
+	defer func() {
+		record.LatencyMs = time.Since(record.CreatedAt).Milliseconds()
+		record.StatusCode = c.Writer.Status()
+		usage.Record(record)
+	}()
+
+	ctx := c.Request.Context()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("chat.model", model.ID), attribute.Bool("chat.stream", req.Stream), attribute.Bool("chat.passthrough", true))
+
+	cosineReq := convertToCosineRequest(req, model)
+	cosineReq.TeamID = cred.TeamID
+	account := &models.Account{Auth: cred.Auth, TeamID: cred.TeamID}
+
+	var resp *http.Response
+	var failedEndpoints []string
+	for i := 0; i < maxRetries; i++ {
+		logger := logging.FromContext(ctx).With("attempt", i+1)
+		client, err := upstream.NewCosineClient(account, failedEndpoints...)
+		if err != nil {
+			logger.Warn("No upstream endpoint for passthrough request", "error", err)
+			break
+		}
+		resp, err = client.SendChatRequest(ctx, cosineReq, cred.Auth)
+		if err != nil {
+			logger.Warn("Upstream request failed", "endpoint", client.Endpoint(), "error", err)
+			metrics.Retry("upstream_error")
+			failedEndpoints = append(failedEndpoints, client.Endpoint())
+			continue
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			resp.Body.Close()
+			sendError(c, http.StatusUnauthorized, "invalid_api_key", "cosine rejected the supplied credential")
+			return
+		}
+		if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+			logger.Info("Upstream rejected passthrough request", "endpoint", client.Endpoint(), "status", resp.StatusCode)
+			sendUpstreamClientError(c, resp)
+			resp.Body.Close()
+			return
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			logger.Warn("Upstream returned error status", "endpoint", client.Endpoint(), "status", resp.StatusCode)
+			metrics.Retry("upstream_status")
+			failedEndpoints = append(failedEndpoints, client.Endpoint())
+			resp.Body.Close()
+			resp = nil
+			continue
+		}
+		logging.With(c, "attempt", i+1, "endpoint", client.Endpoint())
 		break
 	}
 
 	if resp == nil || resp.StatusCode != http.StatusOK {
+		if resp != nil {
+			resp.Body.Close()
+		}
 		sendError(c, http.StatusBadGateway, "upstream_error", "failed to get response from upstream after retries")
 		return
 	}
 	defer resp.Body.Close()
 
+	var finish *models.CosineFinishEvent
 	if req.Stream {
-		handleStreamResponse(c, resp, req.Model)
+		finish = handleStreamResponse(c, resp, model.ID, record.CreatedAt)
 	} else {
-		handleNonStreamResponse(c, resp, req.Model)
+		finish = handleNonStreamResponse(c, resp, model.ID)
 	}
+	record.PromptTokens, record.CompletionTokens = finishUsage(finish)
+	ratelimit.SetUsage(c, record.PromptTokens+record.CompletionTokens)
 }
 
-func convertToCosineRequest(req *models.OpenAIChatRequest) *models.CosineChatRequest {
+// sendUpstreamClientError 把 Cosine 的 4xx 响应转为 OpenAI 格式的错误，保留状态码、
+// Retry-After 和 Cosine 给出的错误信息
+func sendUpstreamClientError(c *gin.Context, resp *http.Response) {
+	message := fmt.Sprintf("cosine returned status %d", resp.StatusCode)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var upstreamErr struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if json.Unmarshal(body, &upstreamErr) == nil {
+		var nested struct {
+			Message string `json:"message"`
+		}
+		var text string
+		switch {
+		case json.Unmarshal(upstreamErr.Error, &text) == nil && text != "":
+			message += ": " + text
+		case json.Unmarshal(upstreamErr.Error, &nested) == nil && nested.Message != "":
+			message += ": " + nested.Message
+		case upstreamErr.Message != "":
+			message += ": " + upstreamErr.Message
+		}
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			c.Header("Retry-After", retryAfter)
+		}
+		sendErrorCode(c, resp.StatusCode, "requests", "rate_limit_exceeded", message)
+		return
+	}
+	sendError(c, resp.StatusCode, "invalid_request_error", message)
+}
+
+// accountFilter 接受属于该路由、能提供该模型且固定端点存在的账户
+func accountFilter(route *routing.Route, model *registry.Model) func(*models.Account) bool {
+	return func(a *models.Account) bool {
+		return route.Accepts(a) && registry.Serves(a.ID, model.Upstream) && upstream.Known(a.Upstream)
+	}
+}
+
+// convertToCosineRequest 转换为 Cosine 请求，模型使用注册表中的上游名称
+func convertToCosineRequest(req *models.OpenAIChatRequest, model *registry.Model) *models.CosineChatRequest {
 	cosineMessages := make([]models.CosineMessage, len(req.Messages))
 	for i, msg := range req.Messages {
 		cosineMessages[i] = models.CosineMessage{
@@ -94,12 +376,23 @@ func convertToCosineRequest(req *models.OpenAIChatRequest) *models.CosineChatReq
 	return &models.CosineChatRequest{
 		ID:         "",
 		Messages:   cosineMessages,
-		Model:      req.Model,
+		Model:      model.Upstream,
 		Visibility: "team",
 	}
 }
 
-func handleStreamResponse(c *gin.Context, resp *http.Response, model string) {
+// handleStreamResponse 转发流式响应，返回最后收到的结束事件；start 为收到请求的时间，用于首 token 耗时
+func handleStreamResponse(c *gin.Context, resp *http.Response, model string, start time.Time) *models.CosineFinishEvent {
+	metrics.ActiveStreams.Inc()
+	defer metrics.ActiveStreams.Dec()
+
+	_, span := tracer.Start(c.Request.Context(), "chat.stream")
+	chunks := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("chat.chunks", chunks))
+		span.End()
+	}()
+
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
 	c.Header("Connection", "keep-alive")
@@ -108,6 +401,8 @@ func handleStreamResponse(c *gin.Context, resp *http.Response, model string) {
 	created := time.Now().Unix()
 
 	eventCh, errCh := upstream.ParseCosineStream(resp.Body)
+	var lastFinish *models.CosineFinishEvent
+	firstToken := true
 
 	c.Stream(func(w io.Writer) bool {
 		select {
@@ -120,6 +415,12 @@ func handleStreamResponse(c *gin.Context, resp *http.Response, model string) {
 
 			switch event.Type {
 			case "content":
+				if firstToken {
+					firstToken = false
+					metrics.ObserveTimeToFirstToken(model, time.Since(start))
+					span.AddEvent("first_token")
+				}
+				chunks++
 				chunk := models.OpenAIChatResponse{
 					ID:      chatID,
 					Object:  "chat.completion.chunk",
@@ -139,6 +440,7 @@ func handleStreamResponse(c *gin.Context, resp *http.Response, model string) {
 				fmt.Fprintf(w, "data: %s\n\n", data)
 
 			case "finish":
+				lastFinish = event.Finish
 				finishReason := "stop"
 				if event.Finish != nil && event.Finish.FinishReason != "" {
 					finishReason = event.Finish.FinishReason
@@ -163,25 +465,34 @@ func handleStreamResponse(c *gin.Context, resp *http.Response, model string) {
 
 		case err := <-errCh:
 			if err != nil {
-				log.Printf("Stream error: %v", err)
+				logging.FromContext(c.Request.Context()).Error("Stream error", "error", err)
+				tracing.RecordError(span, err)
 			}
 			fmt.Fprintf(w, "data: [DONE]\n\n")
 			return false
 		}
 	})
+
+	return lastFinish
 }
 
-func handleNonStreamResponse(c *gin.Context, resp *http.Response, model string) {
+// handleNonStreamResponse 收集完整响应后一次性返回，返回结束事件
+func handleNonStreamResponse(c *gin.Context, resp *http.Response, model string) *models.CosineFinishEvent {
+	_, span := tracer.Start(c.Request.Context(), "chat.read_response")
 	content, finishEvent, err := upstream.CollectFullResponse(resp.Body)
 	if err != nil {
+		tracing.RecordError(span, err)
+		span.End()
 		sendError(c, http.StatusInternalServerError, "internal_error", err.Error())
-		return
+		return nil
 	}
+	span.End()
 
 	finishReason := "stop"
 	if finishEvent != nil && finishEvent.FinishReason != "" {
 		finishReason = finishEvent.FinishReason
 	}
+	prompt, completion := finishUsage(finishEvent)
 
 	response := models.OpenAIChatResponse{
 		ID:      "chatcmpl-" + generateID(24),
@@ -199,16 +510,36 @@ func handleNonStreamResponse(c *gin.Context, resp *http.Response, model string)
 			},
 		},
 		Usage: &models.OpenAIUsage{
-			PromptTokens:     0,
-			CompletionTokens: 0,
-			TotalTokens:      0,
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
 		},
 	}
 
 	c.JSON(http.StatusOK, response)
+	return finishEvent
+}
+
+// finishUsage 返回结束事件中记录的输入和输出 token 数
+func finishUsage(finish *models.CosineFinishEvent) (prompt, completion int) {
+	if finish == nil {
+		return 0, 0
+	}
+	if finish.Usage.PromptTokens != nil {
+		prompt = *finish.Usage.PromptTokens
+	}
+	if finish.Usage.CompletionTokens != nil {
+		completion = *finish.Usage.CompletionTokens
+	}
+	return prompt, completion
 }
 
 func sendError(c *gin.Context, status int, errType, message string) {
+	sendErrorCode(c, status, errType, errType, message)
+}
+
+// sendErrorCode 发送 type 与 code 不同的错误，如 invalid_request_error/model_not_found
+func sendErrorCode(c *gin.Context, status int, errType, code, message string) {
 	c.JSON(status, models.ErrorResponse{
 		Error: struct {
 			Message string `json:"message"`
@@ -217,7 +548,7 @@ func sendError(c *gin.Context, status int, errType, message string) {
 		}{
 			Message: message,
 			Type:    errType,
-			Code:    errType,
+			Code:    code,
 		},
 	})
 }
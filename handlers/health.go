@@ -1,17 +1,136 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"sync/atomic"
 	"time"
 
+	"cosine/config"
+	"cosine/database"
 	"cosine/models"
+	"cosine/upstream"
 
 	"github.com/gin-gonic/gin"
 )
 
 func HealthHandler(c *gin.Context) {
+	stats := database.Stats()
 	c.JSON(http.StatusOK, models.HealthResponse{
 		Status: "ok",
 		Time:   time.Now().UTC().Format(time.RFC3339),
+		Database: &models.DatabaseStats{
+			MaxOpenConnections: stats.MaxOpenConnections,
+			OpenConnections:    stats.OpenConnections,
+			InUse:              stats.InUse,
+			Idle:               stats.Idle,
+			WaitCount:          stats.WaitCount,
+			WaitDurationMs:     stats.WaitDuration.Milliseconds(),
+			MaxIdleClosed:      stats.MaxIdleClosed,
+			MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+		},
 	})
 }
+
+// LivenessHandler serves /healthz. It only shows the process is serving
+// requests; dependency failures are reported by /readyz instead, so an
+// orchestrator does not restart an instance that cannot fix them.
+func LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, models.HealthResponse{
+		Status: "ok",
+		Time:   time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// draining is set once shutdown begins so load balancers stop sending traffic
+var draining atomic.Bool
+
+// StartDraining makes /readyz fail from now on
+func StartDraining() {
+	draining.Store(true)
+}
+
+// ReadinessHandler serves /readyz: the database, the usable accounts and the
+// recent upstream success rate, answering 503 unless all of them pass and the
+// server is not shutting down.
+func ReadinessHandler(c *gin.Context) {
+	cfg := config.Get().Health
+	resp := models.ReadinessResponse{
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Database: checkDatabase(c.Request.Context(), cfg.Timeout),
+		Accounts: checkAccounts(cfg.MinAccounts),
+		Upstream: checkUpstream(cfg),
+		Draining: draining.Load(),
+	}
+
+	status := http.StatusOK
+	resp.Status = "ok"
+	for _, s := range []string{resp.Database.Status, resp.Accounts.Status, resp.Upstream.Status} {
+		if s != "ok" {
+			status = http.StatusServiceUnavailable
+			resp.Status = "fail"
+		}
+	}
+	if resp.Draining {
+		status = http.StatusServiceUnavailable
+		resp.Status = "draining"
+	}
+	c.JSON(status, resp)
+}
+
+func checkDatabase(ctx context.Context, timeout time.Duration) models.DatabaseCheck {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := database.Ping(ctx)
+	check := models.DatabaseCheck{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		check.Status = "fail"
+		check.Error = err.Error()
+	}
+	return check
+}
+
+func checkAccounts(required int) models.AccountsCheck {
+	check := models.AccountsCheck{Status: "ok", Required: required}
+	accounts, err := database.GetActiveAccounts()
+	if err != nil {
+		check.Status = "fail"
+		check.Error = err.Error()
+		return check
+	}
+	for _, acc := range accounts {
+		if upstream.Known(acc.Upstream) {
+			check.Usable++
+		}
+	}
+	if check.Usable < required {
+		check.Status = "fail"
+		check.Error = fmt.Sprintf("%d usable accounts, %d required", check.Usable, required)
+	}
+	return check
+}
+
+func checkUpstream(cfg config.HealthConfig) models.UpstreamCheck {
+	succeeded, failed := upstream.RecentOutcomes(cfg.Window)
+	check := models.UpstreamCheck{
+		Status:         "ok",
+		Window:         cfg.Window.String(),
+		Requests:       succeeded + failed,
+		Failed:         failed,
+		MinSuccessRate: cfg.MinSuccessRate,
+	}
+	if check.Requests == 0 {
+		return check
+	}
+	rate := float64(succeeded) / float64(check.Requests)
+	check.SuccessRate = &rate
+	if check.Requests >= cfg.MinRequests && rate < cfg.MinSuccessRate {
+		check.Status = "fail"
+		check.Error = fmt.Sprintf("%d of %d upstream requests failed in the last %s", failed, check.Requests, cfg.Window)
+	}
+	return check
+}
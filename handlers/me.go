@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"cosine/auth"
+	"cosine/config"
+	"cosine/database"
+	"cosine/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MyAccountsHandler lists the accounts donated by the current user
+// GET /api/me/accounts
+func MyAccountsHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	accounts, err := database.GetAccountsByLinuxdoID(claims.LinuxDoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list accounts: " + err.Error()})
+		return
+	}
+
+	result := make([]accountView, len(accounts))
+	for i, acc := range accounts {
+		result[i] = accountView{Account: acc, Health: acc.Health()}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": result})
+}
+
+// PauseMyAccountHandler takes one of the current user's accounts out of rotation
+// POST /api/me/accounts/:id/pause
+func PauseMyAccountHandler(c *gin.Context) {
+	acc, ok := ownAccount(c)
+	if !ok {
+		return
+	}
+	updateAccount(c, func(id int) error { return database.SetAccountPaused(id, true) }, acc.ID)
+}
+
+// ResumeMyAccountHandler puts a paused account back into rotation
+// POST /api/me/accounts/:id/resume
+func ResumeMyAccountHandler(c *gin.Context) {
+	acc, ok := ownAccount(c)
+	if !ok {
+		return
+	}
+	updateAccount(c, func(id int) error { return database.SetAccountPaused(id, false) }, acc.ID)
+}
+
+// RotateCredentialRequest represents the request body for replacing an account's credential.
+// RefreshToken replaces the stored refresh token; leaving it empty turns automatic renewal off.
+type RotateCredentialRequest struct {
+	Auth         string `json:"auth" binding:"required"`
+	TeamID       string `json:"team_id"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RotateMyAccountCredentialHandler replaces the credential of an account and
+// re-enables it if the upstream had rejected the old one
+// PUT /api/me/accounts/:id/credential
+func RotateMyAccountCredentialHandler(c *gin.Context) {
+	acc, ok := ownAccount(c)
+	if !ok {
+		return
+	}
+
+	var req RotateCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	updateAccount(c, func(id int) error {
+		return database.UpdateAccountCredential(id, req.Auth, req.TeamID, req.RefreshToken)
+	}, acc.ID)
+}
+
+// WithdrawMyAccountHandler permanently removes one of the current user's accounts
+// DELETE /api/me/accounts/:id
+func WithdrawMyAccountHandler(c *gin.Context) {
+	acc, ok := ownAccount(c)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteAccount(acc.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to withdraw account: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account withdrawn", "id": acc.ID})
+}
+
+// ownAccount loads the account in the :id path parameter and checks that the
+// current user donated it. Accounts of other users are reported as not found.
+func ownAccount(c *gin.Context) (*models.Account, bool) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+
+	accountID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account id"})
+		return nil, false
+	}
+
+	acc, err := database.GetAccountByID(accountID)
+	if err != nil || acc.LinuxdoID == nil || *acc.LinuxdoID != claims.LinuxDoID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return nil, false
+	}
+
+	return acc, true
+}
+
+// MyQuotaHandler shows the current user's daily quota, usage and donation credits
+// GET /api/me/quota
+func MyQuotaHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	balance, err := database.GetCreditBalance(claims.LinuxDoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load credits: " + err.Error()})
+		return
+	}
+
+	history, err := database.ListCredits(claims.LinuxDoID, 100)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load credit history: " + err.Error()})
+		return
+	}
+
+	requests, tokens := auth.DailyUsage(claims.LinuxDoID)
+	c.JSON(http.StatusOK, gin.H{
+		"policy": auth.PolicyFor(config.Get(), claims.LinuxDoTrustLevel),
+		"today": gin.H{
+			"requests": requests,
+			"tokens":   tokens,
+		},
+		"credits": balance,
+		"history": history,
+	})
+}
+
+// CreateAPIKeyRequest represents the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name string `json:"name"`
+}
+
+// MyAPIKeysHandler lists the current user's API keys
+// GET /api/me/keys
+func MyAPIKeysHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	keys, err := database.ListAPIKeys(claims.LinuxDoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list api keys: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// CreateMyAPIKeyHandler creates an API key. The key itself is only returned once.
+// POST /api/me/keys
+func CreateMyAPIKeyHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate api key: " + err.Error()})
+		return
+	}
+
+	apiKey, err := database.CreateAPIKey(claims.LinuxDoID, req.Name, auth.HashAPIKey(key), prefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create api key: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// RevokeMyAPIKeyHandler revokes one of the current user's API keys
+// DELETE /api/me/keys/:id
+func RevokeMyAPIKeyHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key id"})
+		return
+	}
+
+	if err := database.RevokeAPIKey(id, claims.LinuxDoID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "api key revoked", "id": id})
+}
@@ -1,23 +1,61 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
 	"cosine/models"
+	"cosine/registry"
 
 	"github.com/gin-gonic/gin"
 )
 
-var supportedModels = []models.OpenAIModel{
-	{ID: "gpt-5", Object: "model", Created: 1700000000, OwnedBy: "cosine"},
-	{ID: "gpt4.1", Object: "model", Created: 1700000000, OwnedBy: "cosine"},
-	{ID: "claude-3-7-sonnet", Object: "model", Created: 1700000000, OwnedBy: "cosine"},
-	{ID: "gemini-2.0-flash", Object: "model", Created: 1700000000, OwnedBy: "cosine"},
-}
+// modelCreated is the fixed creation time reported for every model
+const modelCreated = 1700000000
 
 func ModelsHandler(c *gin.Context) {
+	list := registry.List()
+	data := make([]models.OpenAIModel, len(list))
+	for i, m := range list {
+		data[i] = toOpenAIModel(m)
+	}
 	c.JSON(http.StatusOK, models.OpenAIModelsResponse{
 		Object: "list",
-		Data:   supportedModels,
+		Data:   data,
 	})
 }
+
+// ModelHandler returns a single model; aliases resolve to their model
+func ModelHandler(c *gin.Context) {
+	m, err := registry.Resolve(c.Param("id"))
+	if err != nil {
+		sendModelNotFound(c, c.Param("id"))
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIModel(m))
+}
+
+func toOpenAIModel(m *registry.Model) models.OpenAIModel {
+	om := models.OpenAIModel{
+		ID:      m.ID,
+		Object:  "model",
+		Created: modelCreated,
+		OwnedBy: "cosine",
+		Aliases: m.Aliases,
+	}
+	// Capabilities of discovered models are unknown
+	if !m.Discovered {
+		om.Capabilities = &models.ModelCapabilities{
+			Vision:        m.Vision,
+			Tools:         m.Tools,
+			ContextLength: m.ContextLength,
+		}
+	}
+	return om
+}
+
+// sendModelNotFound answers like OpenAI does for an unknown model
+func sendModelNotFound(c *gin.Context, name string) {
+	sendErrorCode(c, http.StatusNotFound, "invalid_request_error", "model_not_found",
+		fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", name))
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"cosine/auth"
+	"cosine/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultUsageWindow is the period aggregated when no from/to is given
+const defaultUsageWindow = 30 * 24 * time.Hour
+
+// MyUsageHandler shows the current user's recent requests and daily totals
+// GET /api/me/usage?limit=&from=&to=
+func MyUsageHandler(c *gin.Context) {
+	claims, ok := auth.GetClaimsFromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	from, to, ok := usageRange(c)
+	if !ok {
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	records, err := database.ListUsageRecords(claims.LinuxDoID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load usage: " + err.Error()})
+		return
+	}
+
+	daily, err := database.AggregateUsage("day", from, to, &claims.LinuxDoID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to aggregate usage: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"records": records,
+		"daily":   daily,
+	})
+}
+
+// AdminUsageHandler aggregates usage of all users
+// GET /api/admin/usage?group_by=day|model|account|user&from=&to=&linuxdo_id=
+func AdminUsageHandler(c *gin.Context) {
+	from, to, ok := usageRange(c)
+	if !ok {
+		return
+	}
+
+	var linuxDoID *int
+	if v := c.Query("linuxdo_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid linuxdo_id"})
+			return
+		}
+		linuxDoID = &id
+	}
+
+	groupBy := c.DefaultQuery("group_by", "day")
+	aggregates, err := database.AggregateUsage(groupBy, from, to, linuxDoID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group_by": groupBy,
+		"from":     from,
+		"to":       to,
+		"usage":    aggregates,
+	})
+}
+
+// usageRange parses the from/to query parameters (RFC 3339 or YYYY-MM-DD),
+// defaulting to the last 30 days
+func usageRange(c *gin.Context) (from, to time.Time, ok bool) {
+	to = time.Now()
+	from = to.Add(-defaultUsageWindow)
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + p.name + ": " + v})
+			return from, to, false
+		}
+		*p.dst = t
+	}
+
+	return from, to, true
+}
@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"cosine/config"
+)
+
+// level is shared by all handlers so reloads can change it
+var level slog.LevelVar
+
+// Init makes slog, and the standard log package through it, write to stderr
+// in the configured format. The level follows config reloads.
+func Init(cfg *config.LoggingConfig) {
+	setLevel(cfg.Level)
+	slog.SetDefault(slog.New(newHandler(os.Stderr, cfg.Format)))
+
+	config.Subscribe(func(old, new *config.Config) {
+		if old.Logging.Level != new.Logging.Level {
+			setLevel(new.Logging.Level)
+			slog.Info("log level changed", "level", new.Logging.Level)
+		}
+	})
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: &level, ReplaceAttr: redactAttr}
+	if format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+func setLevel(name string) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		l = slog.LevelInfo
+	}
+	level.Set(l)
+}
+
+type contextKey struct{}
+
+// FromContext returns the request logger stored by Middleware, or the
+// default logger outside requests
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// NewContext returns ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged
+var sensitiveKeys = []string{"auth", "authorization", "cookie", "token", "api_key", "secret", "password", "client_secret"}
+
+// secretPatterns match credentials embedded in messages and values: JWTs,
+// API keys, auth cookies and bearer tokens
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	regexp.MustCompile(`sk-cos-[A-Za-z0-9_-]+`),
+	regexp.MustCompile(`(?i)(auth=)[^;\s"]+`),
+	regexp.MustCompile(`(?i)(bearer\s+)[^\s"]+`),
+}
+
+// Redact replaces the credentials in s
+func Redact(s string) string {
+	for _, p := range secretPatterns {
+		if p.NumSubexp() > 0 {
+			s = p.ReplaceAllString(s, "${1}"+redacted)
+		} else {
+			s = p.ReplaceAllString(s, redacted)
+		}
+	}
+	return s
+}
+
+// redactAttr drops the values of sensitive keys and scrubs credentials out of
+// every string, error and stringer, including the message
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, k := range sensitiveKeys {
+		if key == k || strings.HasSuffix(key, "_"+k) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	switch v := a.Value; v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(x.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(x.String()))
+		}
+	}
+	return a
+}
@@ -1,38 +1,149 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"cosine/auth"
 	"cosine/config"
 	"cosine/database"
 	"cosine/handlers"
+	"cosine/logging"
+	"cosine/metrics"
+	"cosine/ratelimit"
+	"cosine/refresh"
+	"cosine/registry"
+	"cosine/rewards"
+	"cosine/tracing"
+	"cosine/upstream"
+	"cosine/usage"
 
 	"github.com/gin-gonic/gin"
 )
 
+const mainUsage = `usage: cosine [-config path] [command]
+
+commands:
+  serve        run the API server (default)
+  migrate      apply or revert database migrations
+  accounts     add, list, disable, enable, probe, import or export accounts
+  users        list, ban or unban users
+  keys         create API keys
+
+Run "cosine <command> -h" for the options of a command.`
+
 func main() {
+	configPath := flag.String("config", defaultConfigPath, "path to the YAML config file; COSINE_* environment variables override it")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), mainUsage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\noptions:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	// Load config
-	cfg, err := config.Load("config.yaml")
+	cfg, err := config.Load(resolveConfigPath(*configPath, flagSet("config")))
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	cmd, args := "serve", flag.Args()
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	// Commands other than serve keep the plain log output
+	switch cmd {
+	case "serve":
+		serve(cfg)
+	case "migrate":
+		runMigrate(cfg, args)
+	case "accounts":
+		runAccounts(cfg, args)
+	case "users":
+		runUsers(cfg, args)
+	case "keys":
+		runKeys(cfg, args)
+	default:
+		log.Fatalf("Unknown command %q\n%s", cmd, mainUsage)
+	}
+}
+
+// serve runs the API server until SIGTERM or SIGINT
+func serve(cfg *config.Config) {
+	// Switch to structured logs
+	logging.Init(&cfg.Logging)
+
+	// SIGTERM or SIGINT starts a graceful shutdown and stops the background jobs
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	// Initialize database
 	if err := database.Init(&cfg.Database); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
 	}
 	defer database.Close()
 
+	// Start the usage writer; Close flushes queued records before the database closes
+	usage.Start()
+	defer usage.Close()
+
+	// Set up the upstream endpoints; reloads rebuild them
+	if err := upstream.Init(&cfg.Upstream); err != nil {
+		fatal("Failed to initialize upstream endpoints", err)
+	}
+
+	// Build the model registry; reloads rebuild it
+	registry.Init(cfg.Models)
+
+	// Start background jobs
+	ratelimit.Init(ctx, &cfg.RateLimit)
+	rewards.Start(ctx, &cfg.Rewards)
+	refresh.Start(ctx, &cfg.TokenRefresh)
+	registry.StartDiscovery(ctx, &cfg.ModelDiscovery)
+
+	// Reload config on SIGHUP or when the file changes
+	config.Watch(ctx)
+
+	// Set up tracing before any request is served
+	shutdownTracing, err := tracing.Init(&cfg.Tracing)
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Setup Gin
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
+	var inflight sync.WaitGroup
+	r.Use(trackRequests(&inflight), tracing.Middleware(), logging.Middleware(), logging.Recovery())
+	if cfg.Metrics.Enabled {
+		if cfg.Metrics.Token == "" {
+			slog.Warn("Metrics are enabled without metrics.token; /metrics is public")
+		}
+		r.Use(metrics.Middleware())
+		metrics.RegisterAccountStates(countAccountStates)
+		r.GET("/metrics", metrics.Handler())
+	}
 
 	// Register routes
 	r.GET("/health", handlers.HealthHandler)
+	r.GET("/healthz", handlers.LivenessHandler)
+	r.GET("/readyz", handlers.ReadinessHandler)
 	r.GET("/v1/models", handlers.ModelsHandler)
-	r.POST("/v1/chat/completions", handlers.ChatCompletionsHandler)
+	r.GET("/v1/models/:id", handlers.ModelHandler)
+	r.POST("/v1/chat/completions", auth.PassthroughMiddleware(), ratelimit.PassthroughLimit(), ratelimit.Middleware(), handlers.ChatCompletionsHandler)
 
 	// LinuxDo OAuth routes
 	r.GET("/api/auth/linuxdo/url", handlers.LinuxDoAuthURLHandler)
@@ -42,13 +153,149 @@ func main() {
 	protected := r.Group("/api")
 	protected.Use(auth.AuthMiddleware())
 	{
+		protected.POST("/auth/refresh", handlers.RefreshTokenHandler)
 		protected.POST("/donate", handlers.DonateHandler)
+
+		// Donor self-service
+		protected.GET("/me/accounts", handlers.MyAccountsHandler)
+		protected.POST("/me/accounts/:id/pause", handlers.PauseMyAccountHandler)
+		protected.POST("/me/accounts/:id/resume", handlers.ResumeMyAccountHandler)
+		protected.PUT("/me/accounts/:id/credential", handlers.RotateMyAccountCredentialHandler)
+		protected.DELETE("/me/accounts/:id", handlers.WithdrawMyAccountHandler)
+		protected.GET("/me/quota", handlers.MyQuotaHandler)
+		protected.GET("/me/usage", handlers.MyUsageHandler)
+		protected.GET("/me/keys", handlers.MyAPIKeysHandler)
+		protected.POST("/me/keys", handlers.CreateMyAPIKeyHandler)
+		protected.DELETE("/me/keys/:id", handlers.RevokeMyAPIKeyHandler)
+	}
+
+	// Admin routes (require JWT auth and admin role)
+	admin := r.Group("/api/admin")
+	admin.Use(auth.AuthMiddleware(), auth.AdminMiddleware())
+	{
+		admin.GET("/users", handlers.AdminListUsersHandler)
+		admin.POST("/users/:linuxdo_id/ban", handlers.AdminBanUserHandler)
+		admin.POST("/users/:linuxdo_id/unban", handlers.AdminUnbanUserHandler)
+		admin.GET("/accounts", handlers.AdminListAccountsHandler)
+		admin.POST("/accounts/import", handlers.AdminImportAccountsHandler)
+		admin.POST("/accounts/export", handlers.AdminExportAccountsHandler)
+		admin.POST("/accounts/:id/activate", handlers.AdminActivateAccountHandler)
+		admin.POST("/accounts/:id/deactivate", handlers.AdminDeactivateAccountHandler)
+		admin.DELETE("/accounts/:id", handlers.AdminDeleteAccountHandler)
+		admin.PUT("/accounts/:id/upstream", handlers.AdminSetAccountUpstreamHandler)
+		admin.PUT("/accounts/:id/proxy", handlers.AdminSetAccountProxyHandler)
+		admin.PUT("/accounts/:id/tags", handlers.AdminSetAccountTagsHandler)
+		admin.PUT("/accounts/:id/weight", handlers.AdminSetAccountWeightHandler)
+		admin.GET("/upstreams", handlers.AdminListUpstreamsHandler)
+		admin.GET("/donations", handlers.AdminListDonationsHandler)
+		admin.GET("/usage", handlers.AdminUsageHandler)
+		admin.POST("/config/reload", handlers.AdminReloadConfigHandler)
 	}
 
 	// Start server
-	addr := fmt.Sprintf(":%d", cfg.Server.Port)
-	log.Printf("Starting cosine2api server on %s", addr)
-	if err := r.Run(addr); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler: r,
 	}
+	go func() {
+		slog.Info("Starting cosine2api server", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("Failed to start server", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop() // a second signal terminates immediately
+	shutdown(srv, &inflight, &cfg.Server)
+	// The deferred calls then export pending spans, flush the usage writer
+	// and close the database, in that order
+}
+
+// shutdown fails /readyz for the drain delay so load balancers take the
+// instance out of rotation, then stops accepting connections and waits for
+// in-flight requests, including open streams, until the shutdown timeout.
+// Requests still running after it are cut off.
+func shutdown(srv *http.Server, inflight *sync.WaitGroup, cfg *config.ServerConfig) {
+	slog.Info("Shutting down", "drain_delay", cfg.DrainDelay.String(), "timeout", cfg.ShutdownTimeout.String())
+	handlers.StartDraining()
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Shutdown timeout reached, closing remaining connections", "error", err)
+		srv.Close()
+	}
+
+	// Closed connections cancel their requests; give the handlers a moment to
+	// return so their usage records are queued before the writer is flushed
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("All requests finished")
+	case <-time.After(handlerExitGrace):
+		slog.Warn("Requests still running after shutdown")
+	}
+}
+
+// handlerExitGrace bounds the wait for handlers after their connections closed
+const handlerExitGrace = 5 * time.Second
+
+// trackRequests counts the requests being handled for shutdown
+func trackRequests(wg *sync.WaitGroup) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		wg.Add(1)
+		defer wg.Done()
+		c.Next()
+	}
+}
+
+// fatal logs err and exits. log.Fatal would log at info level, below a
+// configured warn or error level.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// countAccountStates counts the pool accounts by health state for /metrics
+func countAccountStates() (map[string]int, error) {
+	accounts, err := database.ListAccounts(nil)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]int)
+	for _, acc := range accounts {
+		states[acc.Health()]++
+	}
+	return states, nil
+}
+
+const defaultConfigPath = "config.yaml"
+
+// resolveConfigPath allows running from environment variables alone: a missing
+// config.yaml is skipped unless -config named it explicitly
+func resolveConfigPath(path string, explicit bool) string {
+	if explicit {
+		return path
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		log.Printf("%s not found, configuring from environment variables only", path)
+		return ""
+	}
+	return path
+}
+
+// flagSet reports whether the named flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
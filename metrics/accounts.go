@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var accountsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "accounts"),
+	"Pool accounts by health state.",
+	[]string{"state"}, nil,
+)
+
+// accountCollector counts accounts by state when scraped
+type accountCollector struct {
+	count func() (map[string]int, error)
+}
+
+// RegisterAccountStates exports the pool size by account state, counted by
+// count on every scrape
+func RegisterAccountStates(count func() (map[string]int, error)) {
+	prometheus.MustRegister(accountCollector{count: count})
+}
+
+func (c accountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- accountsDesc
+}
+
+func (c accountCollector) Collect(ch chan<- prometheus.Metric) {
+	states, err := c.count()
+	if err != nil {
+		slog.Error("Failed to count accounts for metrics", "error", err)
+		return
+	}
+	for state, n := range states {
+		ch <- prometheus.MustNewConstMetric(accountsDesc, prometheus.GaugeValue, float64(n), state)
+	}
+}
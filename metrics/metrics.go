@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cosine/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cosine"
+
+// modelKey is the gin context key under which handlers record the model
+const modelKey = "metrics_model"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method, status code and model.",
+	}, []string{"route", "method", "code", "model"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and model, including streamed bodies.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"route", "method", "model"})
+
+	timeToFirstToken = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "chat_time_to_first_token_seconds",
+		Help:      "Time from receiving a streamed chat request to sending its first token.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 30, 60},
+	}, []string{"model"})
+
+	upstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_responses_total",
+		Help:      "Upstream chat responses by account, endpoint and status code; code is \"error\" when no response arrived.",
+	}, []string{"account", "endpoint", "code"})
+
+	chatRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "chat_retries_total",
+		Help:      "Failed upstream attempts of chat requests by reason; each is retried on another account until attempts run out.",
+	}, []string{"reason"})
+
+	tokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Account session token renewals by trigger (expiry, unauthorized or manual) and result (ok or error).",
+	}, []string{"trigger", "result"})
+
+	// ActiveStreams is the number of chat responses being streamed
+	ActiveStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_streams",
+		Help:      "Chat responses currently being streamed.",
+	})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database latency by store operation.",
+		Buckets:   []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+	}, []string{"operation"})
+)
+
+// Handler serves the metrics in the Prometheus text format, requiring
+// metrics.token as bearer token when it is set
+func Handler() gin.HandlerFunc {
+	h := promhttp.Handler()
+	return func(c *gin.Context) {
+		if token := config.Get().Metrics.Token; token != "" {
+			got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Middleware counts and times every request by its route pattern
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		model := c.GetString(modelKey)
+		httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status()), model).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, model).Observe(time.Since(start).Seconds())
+	}
+}
+
+// SetModel labels the request's metrics with the model it used
+func SetModel(c *gin.Context, model string) {
+	c.Set(modelKey, model)
+}
+
+// ObserveTimeToFirstToken records the wait for the first streamed token
+func ObserveTimeToFirstToken(model string, d time.Duration) {
+	timeToFirstToken.WithLabelValues(model).Observe(d.Seconds())
+}
+
+// ObserveUpstream counts an upstream response; status 0 means the request failed
+func ObserveUpstream(accountID int, endpoint string, status int) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	upstreamResponses.WithLabelValues(strconv.Itoa(accountID), endpoint, code).Inc()
+}
+
+// Retry counts a chat attempt retried for the given reason
+func Retry(reason string) {
+	chatRetries.WithLabelValues(reason).Inc()
+}
+
+// TokenRefresh counts a session token renewal
+func TokenRefresh(trigger string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	tokenRefreshes.WithLabelValues(trigger, result).Inc()
+}
+
+// ObserveQuery records the latency of a store operation
+func ObserveQuery(operation string, d time.Duration) {
+	dbQueryDuration.WithLabelValues(operation).Observe(d.Seconds())
+}
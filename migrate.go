@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"cosine/config"
+	"cosine/database"
+)
+
+const migrateUsage = "usage: cosine migrate [up | down [steps] | status]"
+
+// runMigrate implements the migrate subcommand
+func runMigrate(cfg *config.Config, args []string) {
+	if err := database.Open(&cfg.Database); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	err := migrateCommand(args)
+	database.Close()
+	exitOnError(err)
+}
+
+func migrateCommand(args []string) error {
+	ctx := context.Background()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		n, err := database.MigrateUp(ctx)
+		if err != nil {
+			return fmt.Errorf("Migration failed: %w", err)
+		}
+		fmt.Printf("Applied %d migration(s)\n", n)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("Invalid steps %q\n%s", args[1], migrateUsage)
+			}
+		}
+		n, err := database.MigrateDown(ctx, steps)
+		if err != nil {
+			return fmt.Errorf("Migration failed: %w", err)
+		}
+		fmt.Printf("Reverted %d migration(s)\n", n)
+
+	case "status":
+		statuses, err := database.GetMigrationStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("Failed to read migration status: %w", err)
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = "applied " + st.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-28s %s\n", st.Version, st.Name, applied)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
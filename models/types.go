@@ -49,10 +49,18 @@ type OpenAIModelsResponse struct {
 }
 
 type OpenAIModel struct {
-	ID      string `json:"id"`
-	Object  string `json:"object"`
-	Created int64  `json:"created"`
-	OwnedBy string `json:"owned_by"`
+	ID           string             `json:"id"`
+	Object       string             `json:"object"`
+	Created      int64              `json:"created"`
+	OwnedBy      string             `json:"owned_by"`
+	Aliases      []string           `json:"aliases,omitempty"`
+	Capabilities *ModelCapabilities `json:"capabilities,omitempty"`
+}
+
+type ModelCapabilities struct {
+	Vision        bool `json:"vision"`
+	Tools         bool `json:"tools"`
+	ContextLength int  `json:"context_length,omitempty"`
 }
 
 // ===== Cosine 格式 =====
@@ -82,23 +90,119 @@ type CosineFinishEvent struct {
 	IsContinued bool `json:"isContinued,omitempty"`
 }
 
+// Cosine 模型列表响应
+type CosineModelsResponse struct {
+	Models []struct {
+		ID string `json:"id"`
+	} `json:"models"`
+}
+
 // ===== 数据库模型 =====
 
+// 账户停用来源，记录在 Account.DisabledBy
+const (
+	// DisabledByAdmin 表示管理员停用，只有管理员能重新启用
+	DisabledByAdmin = "admin"
+	// DisabledByUpstream 表示上游以 401/403 拒绝了凭证，更换凭证即可恢复
+	DisabledByUpstream = "upstream"
+)
+
+// Account 的 auth 是上游凭证，任何 JSON 输出都不包含它
 type Account struct {
-	ID        int       `json:"id"`
-	Auth      string    `json:"auth"`
-	TeamID    string    `json:"team_id"`
-	LinuxdoID *int      `json:"linuxdo_id"`
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID           int        `json:"id"`
+	Auth         string     `json:"-"`
+	TeamID       string     `json:"team_id"`
+	LinuxdoID    *int       `json:"linuxdo_id"`
+	IsActive     bool       `json:"is_active"`
+	Paused       bool       `json:"paused"`
+	RequestCount int        `json:"request_count"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  *time.Time `json:"last_error_at"`
+	FailCount    int        `json:"fail_count"`
+	Upstream     string     `json:"upstream"`
+	Proxy        string     `json:"-"`
+	Weight       int        `json:"weight"`
+	Tags         []string   `json:"tags"`
+	RefreshToken string     `json:"-"`
+	DisabledBy   string     `json:"disabled_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Health 根据状态和最近的失败次数给出账户健康状态
+func (a *Account) Health() string {
+	switch {
+	case !a.IsActive:
+		return "disabled"
+	case a.Paused:
+		return "paused"
+	case a.FailCount > 0:
+		return "degraded"
+	case a.LastUsedAt == nil:
+		return "unused"
+	default:
+		return "healthy"
+	}
 }
 
 // ===== 通用响应 =====
 
 type HealthResponse struct {
-	Status string `json:"status"`
-	Time   string `json:"time"`
+	Status   string         `json:"status"`
+	Time     string         `json:"time"`
+	Database *DatabaseStats `json:"database,omitempty"`
+}
+
+// DatabaseStats reports the state of the database connection pool
+type DatabaseStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// ReadinessResponse is returned by /readyz. Status is "ok" only when every
+// check is and the server is not shutting down; each check reports "ok" or
+// "fail" with the reason in Error.
+type ReadinessResponse struct {
+	Status   string        `json:"status"`
+	Draining bool          `json:"draining"`
+	Time     string        `json:"time"`
+	Database DatabaseCheck `json:"database"`
+	Accounts AccountsCheck `json:"accounts"`
+	Upstream UpstreamCheck `json:"upstream"`
+}
+
+type DatabaseCheck struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+// AccountsCheck counts active, unpaused accounts on a known upstream endpoint
+type AccountsCheck struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Usable   int    `json:"usable"`
+	Required int    `json:"required"`
+}
+
+// UpstreamCheck covers upstream requests within Window. SuccessRate is nil
+// without requests; below MinRequests the rate is not judged.
+type UpstreamCheck struct {
+	Status         string   `json:"status"`
+	Error          string   `json:"error,omitempty"`
+	Window         string   `json:"window"`
+	Requests       int      `json:"requests"`
+	Failed         int      `json:"failed"`
+	SuccessRate    *float64 `json:"success_rate"`
+	MinSuccessRate float64  `json:"min_success_rate"`
 }
 
 type ErrorResponse struct {
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// errReported fails a command that has already printed why
+var errReported = errors.New("command failed")
+
+// exitOnError ends a command once its cleanup has run: it exits with status 1
+// when err is set, logging err unless the command already reported it
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, errReported) {
+		log.Print(err)
+	}
+	os.Exit(1)
+}
+
+// parseFlags parses args into fs, created with flag.ContinueOnError. The
+// flag package prints parse errors and usage itself.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errReported
+	}
+	return nil
+}
+
+// outputFlag adds the -json flag shared by commands that print records
+func outputFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "print JSON instead of a table")
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("Failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+// printTable writes rows under header as aligned columns
+func printTable(header []string, rows [][]string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// parseIDs parses command arguments as positive record IDs
+func parseIDs(args []string, cmdUsage string) ([]int, error) {
+	if len(args) == 0 {
+		return nil, errors.New(cmdUsage)
+	}
+	ids := make([]int, len(args))
+	for i, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("Invalid id %q\n%s", arg, cmdUsage)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// formatTime formats an optional timestamp for tables
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// truncate shortens s to at most n runes for table cells
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+type memoryCounter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// MemoryStore keeps rate limit state in process memory
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*memoryBucket
+	counters map[string]*memoryCounter
+	slots    map[string]int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*memoryBucket),
+		counters: make(map[string]*memoryCounter),
+		slots:    make(map[string]int),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, perMinute, burst int) (Bucket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	tokens := refill(b.tokens, now.Sub(b.updatedAt), perMinute, burst)
+	tokens, result := take(tokens, perMinute, burst)
+	b.tokens, b.updatedAt = tokens, now
+	return result, nil
+}
+
+func (s *MemoryStore) Add(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		c = &memoryCounter{}
+		s.counters[key] = c
+	}
+	c.value += delta
+	c.expiresAt = expiresAt
+	return c.value, nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || time.Now().After(c.expiresAt) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+func (s *MemoryStore) Acquire(ctx context.Context, key string, limit int) (func(), bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.slots[key] >= limit {
+		return nil, false, nil
+	}
+	s.slots[key]++
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.slots[key]--; s.slots[key] <= 0 {
+				delete(s.slots, key)
+			}
+		})
+	}
+	return release, true, nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, c := range s.counters {
+		if now.After(c.expiresAt) {
+			delete(s.counters, key)
+		}
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.updatedAt) > bucketIdle {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"cosine/database"
+)
+
+// PostgresStore keeps rate limit state in Postgres so several instances share it
+type PostgresStore struct{}
+
+func NewPostgresStore() *PostgresStore {
+	return &PostgresStore{}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, perMinute, burst int) (Bucket, error) {
+	var result Bucket
+	err := database.UpdateRateLimitBucket(key, burst, func(tokens float64, elapsed time.Duration) float64 {
+		tokens, result = take(refill(tokens, elapsed, perMinute, burst), perMinute, burst)
+		return tokens
+	})
+	return result, err
+}
+
+func (s *PostgresStore) Add(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error) {
+	return database.AddRateLimitCounter(key, delta, expiresAt)
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (int64, error) {
+	return database.GetRateLimitCounter(key)
+}
+
+func (s *PostgresStore) Acquire(ctx context.Context, key string, limit int) (func(), bool, error) {
+	id, ok, err := database.AcquireRateLimitSlot(key, limit, slotTTL)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	done := make(chan struct{})
+	go renewSlot(id, done)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			close(done)
+			if err := database.ReleaseRateLimitSlot(id); err != nil {
+				slog.Error("Failed to release rate limit slot", "slot", id, "error", err)
+			}
+		})
+	}
+	return release, true, nil
+}
+
+// renewSlot extends a slot until done is closed, so a long stream keeps it
+// while a crashed instance's slots still expire after slotTTL
+func renewSlot(id int64, done <-chan struct{}) {
+	ticker := time.NewTicker(slotRenew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := database.ExtendRateLimitSlot(id, slotTTL); err != nil {
+				slog.Error("Failed to extend rate limit slot", "slot", id, "error", err)
+				if errors.Is(err, sql.ErrNoRows) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *PostgresStore) Purge(ctx context.Context) error {
+	return database.PurgeRateLimitState(bucketIdle)
+}
@@ -0,0 +1,323 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"cosine/config"
+	"cosine/logging"
+	"cosine/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageTokensKey is the gin context key handlers use to report consumed tokens
+const usageTokensKey = "usage_tokens"
+
+// streamKey is the gin context key caching whether the request asks for a stream
+const streamKey = "ratelimit_stream"
+
+const purgeInterval = 10 * time.Minute
+
+var (
+	storeMu sync.RWMutex
+	store   Store = NewMemoryStore()
+)
+
+// Init selects the configured store and starts purging expired state until ctx
+// is done. A config reload changing rate_limit.store switches to a new store;
+// limits themselves are read from the current config on every request.
+func Init(ctx context.Context, cfg *config.RateLimitConfig) {
+	setStore(cfg.Store)
+	config.Subscribe(func(old, new *config.Config) {
+		if old.RateLimit.Store != new.RateLimit.Store {
+			slog.Info("Rate limit store changed", "from", old.RateLimit.Store, "to", new.RateLimit.Store)
+			setStore(new.RateLimit.Store)
+		}
+	})
+
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Default().Purge(ctx); err != nil {
+					slog.Error("Failed to purge rate limit state", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func setStore(kind string) {
+	var s Store
+	switch kind {
+	case "postgres":
+		s = NewPostgresStore()
+	default:
+		s = NewMemoryStore()
+	}
+
+	storeMu.Lock()
+	store = s
+	storeMu.Unlock()
+}
+
+// Default returns the store selected by Init
+func Default() Store {
+	storeMu.RLock()
+	defer storeMu.RUnlock()
+	return store
+}
+
+// SetUsage reports the tokens consumed by the current request so the
+// middleware can count them against the token quotas
+func SetUsage(c *gin.Context, tokens int) {
+	c.Set(usageTokensKey, tokens)
+}
+
+// LimitsFor returns the limits of the highest configured trust level not above
+// trustLevel, falling back to the default limits
+func LimitsFor(cfg *config.RateLimitConfig, trustLevel int) config.RateLimits {
+	best := -1
+	for level := range cfg.TrustLevels {
+		if level <= trustLevel && level > best {
+			best = level
+		}
+	}
+	if best < 0 {
+		return cfg.Default
+	}
+	return cfg.TrustLevels[best]
+}
+
+// Middleware enforces request rate, concurrent stream and token quotas for
+// the authenticated caller. Quotas are checked first, so a caller over quota
+// keeps its request tokens. It must be used after auth.AuthMiddleware or
+// auth.PassthroughMiddleware.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := &config.Get().RateLimit
+		if !cfg.Enabled {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := logging.FromContext(ctx)
+		store := Default()
+		subject := subjectKey(c, cfg.KeyBy)
+		limits := LimitsFor(cfg, c.GetInt("trust_level"))
+		now := time.Now()
+
+		dayKey, dayEnd := dailyTokensKey(subject, now)
+		monthKey, monthEnd := monthlyTokensKey(subject, now)
+		if !checkTokens(c, store, dayKey, dayEnd, limits.DailyTokens, "daily") ||
+			!checkTokens(c, store, monthKey, monthEnd, limits.MonthlyTokens, "monthly") {
+			return
+		}
+
+		if !takeRequest(c, store, subject, limits.RequestsPerMinute, limits.Burst) {
+			return
+		}
+
+		release, ok := acquireStream(c, store, subject, limits.MaxConcurrentStreams)
+		if !ok {
+			return
+		}
+		defer release()
+
+		c.Next()
+
+		tokens := int64(c.GetInt(usageTokensKey))
+		if tokens <= 0 {
+			return
+		}
+		// The request may outlive its context, so count tokens without it
+		if _, err := store.Add(context.Background(), dayKey, tokens, dayEnd); err != nil {
+			logger.Error("Failed to count daily tokens", "subject", subject, "error", err)
+		}
+		if _, err := store.Add(context.Background(), monthKey, tokens, monthEnd); err != nil {
+			logger.Error("Failed to count monthly tokens", "subject", subject, "error", err)
+		}
+	}
+}
+
+// PassthroughLimit enforces passthrough.per_ip on requests carrying the
+// caller's own Cosine credential, whatever credential it is, so a client
+// cannot lift the per-credential limits by rotating credentials. It must be
+// used after auth.PassthroughMiddleware and applies even while rate_limit is
+// disabled.
+func PassthroughLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("passthrough_id") == "" {
+			c.Next()
+			return
+		}
+
+		limits := config.Get().Passthrough.PerIP
+		store := Default()
+		subject := "passthrough-ip:" + c.ClientIP()
+		if !takeRequest(c, store, subject, limits.RequestsPerMinute, limits.Burst) {
+			return
+		}
+		release, ok := acquireStream(c, store, subject, limits.MaxConcurrentStreams)
+		if !ok {
+			return
+		}
+		defer release()
+
+		c.Next()
+	}
+}
+
+// takeRequest takes one request from the bucket of subject and reports it in
+// the x-ratelimit-*-requests headers. It aborts the request and returns false
+// when the bucket is empty; store errors let the request through.
+func takeRequest(c *gin.Context, store Store, subject string, perMinute, burst int) bool {
+	if perMinute <= 0 {
+		return true
+	}
+	if burst <= 0 {
+		burst = perMinute
+	}
+
+	ctx := c.Request.Context()
+	b, err := store.Take(ctx, "req:"+subject, perMinute, burst)
+	if err != nil {
+		logging.FromContext(ctx).Error("Rate limit store error", "subject", subject, "error", err)
+		return true
+	}
+	c.Header("x-ratelimit-limit-requests", strconv.Itoa(perMinute))
+	c.Header("x-ratelimit-remaining-requests", strconv.Itoa(b.Remaining))
+	c.Header("x-ratelimit-reset-requests", formatReset(b.Reset))
+	if !b.Allowed {
+		c.Header("Retry-After", strconv.Itoa(int(b.RetryAfter.Seconds())+1))
+		abort(c, "requests", "rate_limit_exceeded",
+			fmt.Sprintf("Rate limit reached for requests: limit %d per minute", perMinute))
+		return false
+	}
+	return true
+}
+
+// acquireStream takes one of the limit concurrency slots of subject for a
+// streaming request; other requests take none. It aborts the request and
+// returns false when all are taken; otherwise the returned release func is
+// not nil. Store errors let the request through.
+func acquireStream(c *gin.Context, store Store, subject string, limit int) (func(), bool) {
+	if limit <= 0 || !isStream(c) {
+		return func() {}, true
+	}
+
+	ctx := c.Request.Context()
+	release, ok, err := store.Acquire(ctx, "streams:"+subject, limit)
+	if err != nil {
+		logging.FromContext(ctx).Error("Rate limit store error", "subject", subject, "error", err)
+		return func() {}, true
+	}
+	if !ok {
+		abort(c, "requests", "rate_limit_exceeded", fmt.Sprintf("Too many concurrent streams: limit %d", limit))
+		return nil, false
+	}
+	return release, true
+}
+
+// isStream reports whether the chat request body sets stream. The body is
+// put back for the handler, which rejects it if it is not valid JSON.
+func isStream(c *gin.Context) bool {
+	if stream, ok := c.Get(streamKey); ok {
+		return stream.(bool)
+	}
+
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if c.Request.Body != nil {
+		data, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		if err == nil {
+			json.Unmarshal(data, &req)
+		}
+	}
+	c.Set(streamKey, req.Stream)
+	return req.Stream
+}
+
+// checkTokens rejects the request when the token quota of a window is used up.
+// The daily window is reported in the x-ratelimit-*-tokens headers.
+func checkTokens(c *gin.Context, store Store, key string, end time.Time, limit int64, window string) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	used, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Rate limit store error", "key", key, "error", err)
+		return true
+	}
+
+	remaining := max(0, limit-used)
+	if window == "daily" || c.Writer.Header().Get("x-ratelimit-limit-tokens") == "" {
+		c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(limit, 10))
+		c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(remaining, 10))
+		c.Header("x-ratelimit-reset-tokens", formatReset(time.Until(end)))
+	}
+
+	if remaining == 0 {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(end).Seconds())+1))
+		abort(c, "tokens", "insufficient_quota",
+			fmt.Sprintf("You exceeded your %s token quota of %d tokens", window, limit))
+		return false
+	}
+	return true
+}
+
+// subjectKey identifies who the limits apply to: the caller's own Cosine
+// credential for passthrough requests, the API key when keying by API key and
+// the request used one, the user otherwise
+func subjectKey(c *gin.Context, keyBy string) string {
+	if id := c.GetString("passthrough_id"); id != "" {
+		return "passthrough:" + id
+	}
+	if keyBy == "api_key" {
+		if id, ok := c.Get("api_key_id"); ok {
+			return fmt.Sprintf("key:%d", id)
+		}
+	}
+	return fmt.Sprintf("user:%d", c.GetInt("linuxdo_id"))
+}
+
+func dailyTokensKey(subject string, now time.Time) (string, time.Time) {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return "tokens:day:" + subject + ":" + start.Format("2006-01-02"), start.AddDate(0, 0, 1)
+}
+
+func monthlyTokensKey(subject string, now time.Time) (string, time.Time) {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return "tokens:month:" + subject + ":" + start.Format("2006-01"), start.AddDate(0, 1, 0)
+}
+
+// formatReset formats a duration like OpenAI's reset headers, e.g. "1s" or "6m0s"
+func formatReset(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func abort(c *gin.Context, errType, code, message string) {
+	var resp models.ErrorResponse
+	resp.Error.Message = message
+	resp.Error.Type = errType
+	resp.Error.Code = code
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, resp)
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func chatContext(body string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	return c, w
+}
+
+func TestAcquireStreamCountsOnlyStreams(t *testing.T) {
+	store := NewMemoryStore()
+	stream := `{"model":"gpt-5","stream":true}`
+
+	c, _ := chatContext(stream)
+	release, ok := acquireStream(c, store, "user:1", 1)
+	if !ok {
+		t.Fatal("first stream refused")
+	}
+	if body, _ := io.ReadAll(c.Request.Body); string(body) != stream {
+		t.Fatalf("body not restored for the handler: %q", body)
+	}
+
+	c, _ = chatContext(`{"model":"gpt-5"}`)
+	if _, ok := acquireStream(c, store, "user:1", 1); !ok {
+		t.Fatal("non-stream request refused while the stream slots are taken")
+	}
+
+	c, w := chatContext(stream)
+	if _, ok := acquireStream(c, store, "user:1", 1); ok || w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second stream allowed over the limit: ok %v, status %d", ok, w.Code)
+	}
+
+	release()
+	c, _ = chatContext(stream)
+	if _, ok := acquireStream(c, store, "user:1", 1); !ok {
+		t.Fatal("released slot not reusable")
+	}
+}
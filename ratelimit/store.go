@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Bucket is the state of a token bucket after a Take
+type Bucket struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next request is allowed, zero when allowed
+}
+
+// Store keeps rate limit state. The memory store serves a single instance;
+// the Postgres store shares state between instances.
+type Store interface {
+	// Take removes one request from the bucket of key, which refills at
+	// perMinute requests per minute up to burst.
+	Take(ctx context.Context, key string, perMinute, burst int) (Bucket, error)
+	// Add adds delta to the counter key and returns its new value. The
+	// counter is dropped after expiresAt.
+	Add(ctx context.Context, key string, delta int64, expiresAt time.Time) (int64, error)
+	// Get returns the value of the counter key, 0 if it does not exist.
+	Get(ctx context.Context, key string) (int64, error)
+	// Acquire takes one of limit concurrency slots for key. The returned
+	// release func must be called once the slot is no longer used.
+	Acquire(ctx context.Context, key string, limit int) (release func(), ok bool, err error)
+	// Purge drops expired state.
+	Purge(ctx context.Context) error
+}
+
+// slotTTL bounds how long a slot held by a crashed instance stays taken. A
+// live holder extends its slot every slotRenew, so streams may outlast it.
+const (
+	slotTTL   = 2 * time.Minute
+	slotRenew = slotTTL / 4
+)
+
+// bucketIdle is how long an untouched bucket is kept; a bucket idle that long is full anyway
+const bucketIdle = time.Hour
+
+// refill adds the requests earned during elapsed to a bucket holding tokens
+func refill(tokens float64, elapsed time.Duration, perMinute, burst int) float64 {
+	rate := float64(perMinute) / 60
+	return math.Min(float64(burst), tokens+elapsed.Seconds()*rate)
+}
+
+// take removes one request from a refilled bucket and describes the result
+func take(tokens float64, perMinute, burst int) (float64, Bucket) {
+	rate := float64(perMinute) / 60
+	var b Bucket
+	if tokens >= 1 {
+		tokens--
+		b.Allowed = true
+	} else {
+		b.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	b.Remaining = int(tokens)
+	b.Reset = seconds((float64(burst) - tokens) / rate)
+	return tokens, b
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
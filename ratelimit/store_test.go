@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefill(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "nothing elapsed", tokens: 2, want: 2},
+		{name: "one request per second", tokens: 0, elapsed: 3 * time.Second, want: 3},
+		{name: "fraction of a request", tokens: 1, elapsed: 500 * time.Millisecond, want: 1.5},
+		{name: "capped at burst", tokens: 4, elapsed: time.Minute, want: 5},
+	}
+	for _, tt := range tests {
+		if got := refill(tt.tokens, tt.elapsed, 60, 5); got != tt.want {
+			t.Errorf("%s: refill = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	tests := []struct {
+		name   string
+		tokens float64
+		left   float64
+		want   Bucket
+	}{
+		{name: "full bucket", tokens: 5, left: 4,
+			want: Bucket{Allowed: true, Remaining: 4, Reset: 1 * time.Second}},
+		{name: "last request", tokens: 1, left: 0,
+			want: Bucket{Allowed: true, Remaining: 0, Reset: 5 * time.Second}},
+		{name: "empty bucket", tokens: 0, left: 0,
+			want: Bucket{RetryAfter: time.Second, Reset: 5 * time.Second}},
+		{name: "partly refilled", tokens: 0.5, left: 0.5,
+			want: Bucket{RetryAfter: 500 * time.Millisecond, Reset: 4500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		left, got := take(tt.tokens, 60, 5)
+		if left != tt.left || got != tt.want {
+			t.Errorf("%s: take = %v, %+v, want %v, %+v", tt.name, left, got, tt.left, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for i := 3; i > 0; i-- {
+		b, err := s.Take(ctx, "req:a", 1, 3)
+		if err != nil || !b.Allowed || b.Remaining != i-1 {
+			t.Fatalf("request %d: Take = %+v, %v", 4-i, b, err)
+		}
+	}
+	b, _ := s.Take(ctx, "req:a", 1, 3)
+	if b.Allowed || b.RetryAfter <= 0 || b.RetryAfter > time.Minute {
+		t.Fatalf("Take on an empty bucket = %+v", b)
+	}
+	if b, _ := s.Take(ctx, "req:b", 1, 3); !b.Allowed {
+		t.Fatal("buckets are not kept per key")
+	}
+
+	// An idle bucket refills up to burst
+	s.buckets["req:a"].updatedAt = time.Now().Add(-time.Hour)
+	if b, _ := s.Take(ctx, "req:a", 1, 3); !b.Allowed || b.Remaining != 2 {
+		t.Fatalf("Take after refilling = %+v", b)
+	}
+}
@@ -0,0 +1,211 @@
+// Package refresh renews the Cosine session tokens of accounts that store a
+// refresh token: in the background before they expire, and on demand when
+// Cosine rejects one.
+package refresh
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+	"cosine/metrics"
+	"cosine/models"
+	"cosine/upstream"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Renewal triggers, reported in logs and metrics
+const (
+	TriggerExpiry       = "expiry"
+	TriggerUnauthorized = "unauthorized"
+	TriggerManual       = "manual"
+)
+
+// requestTimeout bounds one token endpoint request
+const requestTimeout = 15 * time.Second
+
+var (
+	// ErrDisabled is returned while token_refresh is disabled
+	ErrDisabled = errors.New("token refresh is disabled")
+	// ErrNoRefreshToken is returned for accounts without a refresh token
+	ErrNoRefreshToken = errors.New("account has no refresh token")
+)
+
+// locks holds a *sync.Mutex per account ID so one instance renews a token once
+var locks sync.Map
+
+// Start periodically renews the session tokens about to expire until ctx is
+// done. It does nothing while token refresh is disabled; config reloads can
+// enable, disable or retune it.
+func Start(ctx context.Context, cfg *config.TokenRefreshConfig) {
+	config.RunPeriodic(ctx, *cfg, config.PeriodicJob[config.TokenRefreshConfig]{
+		Name:   "Token refresh",
+		Select: func(c *config.Config) config.TokenRefreshConfig { return c.TokenRefresh },
+		Schedule: func(cfg config.TokenRefreshConfig) (bool, time.Duration) {
+			return cfg.Enabled, cfg.Interval
+		},
+		Run: func(ctx context.Context, cfg config.TokenRefreshConfig) {
+			renewExpiring(ctx, &cfg)
+		},
+		RunOnEnable: true,
+	})
+}
+
+// renewExpiring renews the tokens of active accounts expiring within
+// cfg.Before. Tokens without a readable expiry are only renewed on 401.
+func renewExpiring(ctx context.Context, cfg *config.TokenRefreshConfig) {
+	accounts, err := database.GetActiveAccounts()
+	if err != nil {
+		slog.Error("Token refresh failed to list accounts", "error", err)
+		return
+	}
+
+	deadline := time.Now().Add(cfg.Before)
+	for i := range accounts {
+		acc := &accounts[i]
+		if acc.RefreshToken == "" {
+			continue
+		}
+		if exp, ok := ExpiresAt(acc.Auth); !ok || exp.After(deadline) {
+			continue
+		}
+		if _, err := Refresh(ctx, acc, TriggerExpiry); err != nil {
+			slog.Warn("Failed to renew session token", "account_id", acc.ID, "error", err)
+		}
+	}
+}
+
+// ExpiresAt returns the expiry of a JWT session token. The signature is not
+// checked; ok is false for tokens that are not JWTs or carry no expiry.
+func ExpiresAt(token string) (time.Time, bool) {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}, false
+	}
+	return claims.ExpiresAt.Time, true
+}
+
+// Refresh renews the session token of acc and returns the updated account.
+// Concurrent renewals of one account exchange the refresh token once: a call
+// finding the token already replaced since acc was loaded returns the
+// current account. The row is updated only if its token is still the one
+// renewed, so selection never sees a half-written credential.
+func Refresh(ctx context.Context, acc *models.Account, trigger string) (*models.Account, error) {
+	cfg := config.Get().TokenRefresh
+	if !cfg.Enabled {
+		return nil, ErrDisabled
+	}
+
+	lock, _ := locks.LoadOrStore(acc.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	current, err := database.GetAccountByID(acc.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Auth != acc.Auth {
+		return current, nil
+	}
+	if current.RefreshToken == "" {
+		return nil, ErrNoRefreshToken
+	}
+
+	tokens, err := exchange(ctx, &cfg, current)
+	metrics.TokenRefresh(trigger, err)
+	if err != nil {
+		database.RecordAccountFailure(acc.ID, "token refresh failed: "+err.Error())
+		return nil, err
+	}
+
+	// Another instance may have renewed the token meanwhile; its token wins
+	err = database.ReplaceAccountToken(acc.ID, current.Auth, tokens.IDToken, tokens.RefreshToken)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil {
+		slog.Info("Renewed session token", "account_id", acc.ID, "trigger", trigger, "expires_in", tokens.ExpiresIn)
+	}
+	return database.GetAccountByID(acc.ID)
+}
+
+// tokenResponse is the answer of the Firebase secure token endpoint
+type tokenResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    string `json:"expires_in"`
+	Error        *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// exchange trades the account's refresh token for a new session token,
+// through the account's proxy. It does not depend on the Cosine endpoints, so
+// an endpoint in cooldown does not stop renewals.
+func exchange(ctx context.Context, cfg *config.TokenRefreshConfig, acc *models.Account) (*tokenResponse, error) {
+	client, err := upstream.ExternalClient(acc)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(cfg.TokenURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token_url: %w", err)
+	}
+	query := endpoint.Query()
+	query.Set("key", cfg.APIKey)
+	endpoint.RawQuery = query.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {acc.RefreshToken}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL carries the API key, keep it out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens tokenResponse
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token response: %w", err)
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if tokens.Error != nil && tokens.Error.Message != "" {
+			return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, tokens.Error.Message)
+		}
+		return nil, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token endpoint returned no id_token")
+	}
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = acc.RefreshToken
+	}
+	return &tokens, nil
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+	"cosine/models"
+	"cosine/upstream"
+)
+
+const (
+	defaultDiscoveryInterval = 30 * time.Minute
+
+	// probeTimeout bounds the model list request for one account
+	probeTimeout = 15 * time.Second
+
+	// probeConcurrency is the number of accounts probed at once
+	probeConcurrency = 4
+)
+
+var (
+	// availMu guards available
+	availMu sync.RWMutex
+	// available maps account IDs to the Cosine models they offer; accounts
+	// not probed yet are missing
+	available = make(map[int]map[string]bool)
+)
+
+// Serves reports whether the account can serve the Cosine model. Accounts
+// that have not been probed yet are assumed to serve every model.
+func Serves(accountID int, upstreamModel string) bool {
+	availMu.RLock()
+	defer availMu.RUnlock()
+	offered, ok := available[accountID]
+	return !ok || offered[upstreamModel]
+}
+
+// StartDiscovery periodically asks Cosine which models each active account
+// offers until ctx is done. It does nothing while discovery is disabled;
+// config reloads can enable, disable or retune it.
+func StartDiscovery(ctx context.Context, cfg *config.ModelDiscoveryConfig) {
+	config.RunPeriodic(ctx, *cfg, config.PeriodicJob[config.ModelDiscoveryConfig]{
+		Name:   "Model discovery",
+		Select: func(c *config.Config) config.ModelDiscoveryConfig { return c.ModelDiscovery },
+		Schedule: func(cfg config.ModelDiscoveryConfig) (bool, time.Duration) {
+			return cfg.Enabled, discoveryIntervalOf(&cfg)
+		},
+		Run: func(ctx context.Context, _ config.ModelDiscoveryConfig) {
+			discover(ctx)
+		},
+		RunOnEnable: true,
+		OnDisable:   forget,
+	})
+}
+
+func discoveryIntervalOf(cfg *config.ModelDiscoveryConfig) time.Duration {
+	if cfg.Interval <= 0 {
+		return defaultDiscoveryInterval
+	}
+	return cfg.Interval
+}
+
+// discover probes the active accounts, a few at a time, and publishes the
+// union of their models. Accounts whose probe fails keep their previous models.
+func discover(ctx context.Context) {
+	accounts, err := database.GetActiveAccounts()
+	if err != nil {
+		slog.Error("Model discovery failed to list accounts", "error", err)
+		return
+	}
+
+	availMu.RLock()
+	next := make(map[int]map[string]bool, len(accounts))
+	for _, a := range accounts {
+		if offered, ok := available[a.ID]; ok {
+			next[a.ID] = offered
+		}
+	}
+	availMu.RUnlock()
+
+	// results[i] stays nil when probing accounts[i] fails
+	results := make([]map[string]bool, len(accounts))
+	sem := make(chan struct{}, probeConcurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			names, err := probe(ctx, &accounts[i])
+			if err != nil {
+				slog.Warn("Model discovery failed for account", "account_id", accounts[i].ID, "error", err)
+				return
+			}
+			offered := make(map[string]bool, len(names))
+			for _, name := range names {
+				offered[name] = true
+			}
+			results[i] = offered
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, a := range accounts {
+		if results[i] == nil {
+			failed++
+			continue
+		}
+		next[a.ID] = results[i]
+	}
+
+	var union []string
+	for _, offered := range next {
+		for name := range offered {
+			if !slices.Contains(union, name) {
+				union = append(union, name)
+			}
+		}
+	}
+	slices.Sort(union)
+
+	availMu.Lock()
+	available = next
+	availMu.Unlock()
+	setDiscovered(union)
+
+	slog.Info("Model discovery finished", "models", len(union), "accounts", len(accounts)-failed, "failed", failed)
+}
+
+// probe lists the models of one account on its upstream endpoint
+func probe(ctx context.Context, account *models.Account) ([]string, error) {
+	client, err := upstream.NewCosineClient(account)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return client.ListModels(ctx, account.Auth)
+}
+
+// forget drops discovered models and per-account availability
+func forget() {
+	availMu.Lock()
+	available = make(map[int]map[string]bool)
+	availMu.Unlock()
+	setDiscovered(nil)
+}
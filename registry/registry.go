@@ -0,0 +1,126 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"cosine/config"
+)
+
+// ErrModelNotFound is returned for names that are unknown or disabled
+var ErrModelNotFound = errors.New("model not found")
+
+// Model is a model served through /v1
+type Model struct {
+	ID            string
+	Upstream      string
+	Aliases       []string
+	Vision        bool
+	Tools         bool
+	ContextLength int
+	// Discovered is set for models found on Cosine but missing from the config
+	Discovered bool
+}
+
+type snapshot struct {
+	models []*Model
+	byName map[string]*Model
+}
+
+var (
+	current atomic.Pointer[snapshot]
+
+	// buildMu guards the inputs of the snapshot
+	buildMu    sync.Mutex
+	configured []config.ModelConfig
+	discovered []string
+)
+
+// Init builds the registry from the models config and rebuilds it whenever a
+// config reload changes them
+func Init(cfg []config.ModelConfig) {
+	setConfigured(cfg)
+	config.Subscribe(func(old, new *config.Config) {
+		setConfigured(new.Models)
+	})
+}
+
+func setConfigured(cfg []config.ModelConfig) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	configured = cfg
+	current.Store(build(configured, discovered))
+}
+
+func setDiscovered(names []string) {
+	buildMu.Lock()
+	defer buildMu.Unlock()
+	discovered = names
+	current.Store(build(configured, discovered))
+}
+
+// build merges the configured models with the discovered Cosine model names.
+// Configured entries win, so a disabled entry also hides the discovered model.
+func build(cfg []config.ModelConfig, upstream []string) *snapshot {
+	s := &snapshot{byName: make(map[string]*Model)}
+	known := make(map[string]bool)
+	for _, mc := range cfg {
+		m := &Model{
+			ID:            mc.ID,
+			Upstream:      mc.Upstream,
+			Aliases:       mc.Aliases,
+			Vision:        mc.Vision,
+			Tools:         mc.Tools,
+			ContextLength: mc.ContextLength,
+		}
+		if m.Upstream == "" {
+			m.Upstream = m.ID
+		}
+		known[m.ID] = true
+		known[m.Upstream] = true
+		if mc.Disabled {
+			continue
+		}
+		s.add(m)
+	}
+
+	for _, name := range upstream {
+		if known[name] {
+			continue
+		}
+		known[name] = true
+		s.add(&Model{ID: name, Upstream: name, Discovered: true})
+	}
+	return s
+}
+
+func (s *snapshot) add(m *Model) {
+	s.models = append(s.models, m)
+	s.byName[m.ID] = m
+	for _, alias := range m.Aliases {
+		s.byName[alias] = m
+	}
+}
+
+// Resolve returns the enabled model named by an ID or alias
+func Resolve(name string) (*Model, error) {
+	s := current.Load()
+	if s == nil {
+		return nil, ErrModelNotFound
+	}
+	m, ok := s.byName[name]
+	if !ok {
+		return nil, ErrModelNotFound
+	}
+	return m, nil
+}
+
+// List returns the enabled models, configured ones first in config order
+func List() []*Model {
+	s := current.Load()
+	if s == nil {
+		return nil
+	}
+	return s.models
+}
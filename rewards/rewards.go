@@ -0,0 +1,47 @@
+package rewards
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"cosine/config"
+	"cosine/database"
+)
+
+const defaultInterval = time.Hour
+
+// Start periodically credits donors for their healthy accounts until ctx is done.
+// It does nothing while rewards are disabled; config reloads can enable,
+// disable or retune it.
+func Start(ctx context.Context, cfg *config.RewardsConfig) {
+	config.RunPeriodic(ctx, *cfg, config.PeriodicJob[config.RewardsConfig]{
+		Name:   "Donation rewards",
+		Select: func(c *config.Config) config.RewardsConfig { return c.Rewards },
+		Schedule: func(cfg config.RewardsConfig) (bool, time.Duration) {
+			return cfg.Enabled, intervalOf(&cfg)
+		},
+		Run: func(ctx context.Context, cfg config.RewardsConfig) {
+			grant(&cfg, intervalOf(&cfg))
+		},
+	})
+}
+
+func intervalOf(cfg *config.RewardsConfig) time.Duration {
+	if cfg.Interval <= 0 {
+		return defaultInterval
+	}
+	return cfg.Interval
+}
+
+func grant(cfg *config.RewardsConfig, interval time.Duration) {
+	// Allow one missed tick so a slow run does not lose credits
+	n, err := database.GrantDonationRewards(cfg.RequestsPerHour, cfg.TokensPerHour, 2*interval)
+	if err != nil {
+		slog.Error("Failed to grant donation rewards", "error", err)
+		return
+	}
+	if n > 0 {
+		slog.Info("Granted donation rewards", "accounts", n)
+	}
+}
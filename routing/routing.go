@@ -0,0 +1,93 @@
+// Package routing decides which accounts may serve a request, following the
+// routing rules of the config: private account groups serve only the users,
+// API keys or models routed to them, the rest form the shared pool.
+package routing
+
+import (
+	"fmt"
+	"slices"
+
+	"cosine/config"
+	"cosine/models"
+)
+
+// Request holds what routing rules match on
+type Request struct {
+	LinuxDoID int
+	APIKeyID  *int
+	Model     string
+}
+
+// Route is the group of accounts a request may use
+type Route struct {
+	// Rule names the matched rule, empty when no rule matched
+	Rule string
+	// Tags selects the accounts carrying any of them; empty selects the
+	// shared pool
+	Tags []string
+	// Fallback allows the shared pool while no selected account is available
+	Fallback bool
+
+	reserved []string
+}
+
+// Resolve returns the route of the first rule matching req, or the shared
+// pool when none does
+func Resolve(cfg *config.RoutingConfig, req Request) Route {
+	for i, rule := range cfg.Rules {
+		if !matches(&rule, req) {
+			continue
+		}
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		return Route{Rule: name, Tags: rule.Tags, Fallback: rule.Fallback, reserved: cfg.ReservedTags}
+	}
+	return Shared(cfg)
+}
+
+// Shared returns the route to the shared pool
+func Shared(cfg *config.RoutingConfig) Route {
+	return Route{reserved: cfg.ReservedTags}
+}
+
+func matches(rule *config.RoutingRule, req Request) bool {
+	if len(rule.Users) > 0 && !slices.Contains(rule.Users, req.LinuxDoID) {
+		return false
+	}
+	if len(rule.APIKeys) > 0 && (req.APIKeyID == nil || !slices.Contains(rule.APIKeys, *req.APIKeyID)) {
+		return false
+	}
+	if len(rule.Models) > 0 && !slices.Contains(rule.Models, req.Model) {
+		return false
+	}
+	return true
+}
+
+// Accepts reports whether the account belongs to the route. An account
+// carrying reserved tags is accepted only when the route selects one of
+// them, so private seats never serve the shared pool.
+func (r *Route) Accepts(acc *models.Account) bool {
+	reserved := false
+	for _, tag := range acc.Tags {
+		if slices.Contains(r.reserved, tag) {
+			reserved = true
+			if slices.Contains(r.Tags, tag) {
+				return true
+			}
+		}
+	}
+	if reserved {
+		return false
+	}
+	if len(r.Tags) == 0 {
+		return true
+	}
+	for _, tag := range acc.Tags {
+		if slices.Contains(r.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
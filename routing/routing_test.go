@@ -0,0 +1,86 @@
+package routing
+
+import (
+	"slices"
+	"testing"
+
+	"cosine/config"
+	"cosine/models"
+)
+
+func TestResolve(t *testing.T) {
+	keyID := 7
+	otherKeyID := 8
+	cfg := &config.RoutingConfig{
+		ReservedTags: []string{"team-a"},
+		Rules: []config.RoutingRule{
+			{Name: "team-a", Users: []int{1, 2}, Tags: []string{"team-a"}},
+			{Name: "ci", APIKeys: []int{keyID}, Models: []string{"gpt-5"}, Tags: []string{"ci"}, Fallback: true},
+			{Users: []int{3}},
+			{Users: []int{1}, Tags: []string{"never"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		req      Request
+		rule     string
+		tags     []string
+		fallback bool
+	}{
+		{name: "user rule", req: Request{LinuxDoID: 1, Model: "gpt-5"}, rule: "team-a", tags: []string{"team-a"}},
+		{name: "first match wins", req: Request{LinuxDoID: 2, APIKeyID: &keyID, Model: "gpt-5"}, rule: "team-a", tags: []string{"team-a"}},
+		{name: "all selectors match", req: Request{LinuxDoID: 9, APIKeyID: &keyID, Model: "gpt-5"}, rule: "ci", tags: []string{"ci"}, fallback: true},
+		{name: "model does not match", req: Request{LinuxDoID: 9, APIKeyID: &keyID, Model: "claude"}},
+		{name: "other api key", req: Request{LinuxDoID: 9, APIKeyID: &otherKeyID, Model: "gpt-5"}},
+		{name: "no api key", req: Request{LinuxDoID: 9, Model: "gpt-5"}},
+		{name: "unnamed rule", req: Request{LinuxDoID: 3, Model: "gpt-5"}, rule: "#3"},
+		{name: "no rule", req: Request{LinuxDoID: 4, Model: "gpt-5"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Resolve(cfg, tt.req)
+			if r.Rule != tt.rule || !slices.Equal(r.Tags, tt.tags) || r.Fallback != tt.fallback {
+				t.Fatalf("Resolve = {Rule:%q Tags:%v Fallback:%v}, want {Rule:%q Tags:%v Fallback:%v}",
+					r.Rule, r.Tags, r.Fallback, tt.rule, tt.tags, tt.fallback)
+			}
+			if !slices.Equal(r.reserved, cfg.ReservedTags) {
+				t.Fatalf("Resolve dropped the reserved tags: %v", r.reserved)
+			}
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	cfg := &config.RoutingConfig{ReservedTags: []string{"team-a", "team-b"}}
+	shared := Shared(cfg)
+	teamA := Route{Rule: "team-a", Tags: []string{"team-a"}, reserved: cfg.ReservedTags}
+	gpu := Route{Rule: "gpu", Tags: []string{"gpu"}, reserved: cfg.ReservedTags}
+
+	tests := []struct {
+		name  string
+		route Route
+		tags  []string
+		want  bool
+	}{
+		{name: "shared pool takes untagged accounts", route: shared, want: true},
+		{name: "shared pool takes unreserved tags", route: shared, tags: []string{"gpu"}, want: true},
+		{name: "shared pool refuses reserved accounts", route: shared, tags: []string{"team-a"}},
+		{name: "shared pool refuses partly reserved accounts", route: shared, tags: []string{"gpu", "team-b"}},
+		{name: "group takes its reserved accounts", route: teamA, tags: []string{"team-a"}, want: true},
+		{name: "group takes its accounts with other tags", route: teamA, tags: []string{"gpu", "team-a"}, want: true},
+		{name: "group refuses other reserved accounts", route: teamA, tags: []string{"team-b"}},
+		{name: "group refuses untagged accounts", route: teamA},
+		{name: "unreserved group takes tagged accounts", route: gpu, tags: []string{"gpu"}, want: true},
+		{name: "unreserved group refuses reserved accounts", route: gpu, tags: []string{"gpu", "team-a"}},
+		{name: "unreserved group refuses other accounts", route: gpu, tags: []string{"cpu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acc := &models.Account{Tags: tt.tags}
+			if got := tt.route.Accepts(acc); got != tt.want {
+				t.Fatalf("Accepts(%v) = %v, want %v", tt.tags, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,36 +3,153 @@ package upstream
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"cosine/config"
 	"cosine/models"
+	"cosine/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("cosine/upstream")
+
 type CosineClient struct {
+	endpoint   *Endpoint
 	baseURL    string
 	httpClient *http.Client
 }
 
-func NewCosineClient() *CosineClient {
+// NewCosineClient 为账户创建客户端，端点选择见 Select；账户设置了代理时使用该代理
+func NewCosineClient(account *models.Account, avoid ...string) (*CosineClient, error) {
+	e, err := Select(account.Upstream, avoid...)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := e.client(account.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("account %d: %w", account.ID, err)
+	}
 	return &CosineClient{
-		baseURL:    config.GlobalConfig.Upstream.BaseURL,
-		httpClient: &http.Client{},
+		endpoint:   e,
+		baseURL:    e.BaseURL,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Endpoint 返回客户端使用的端点名称
+func (c *CosineClient) Endpoint() string {
+	return c.endpoint.Name
+}
+
+// do 添加配置的请求头后发送请求，并记录端点健康状态，网络错误和 5xx 计为端点失败。
+// 响应体在 idle_timeout 内没有新数据时会被中断，避免卡住的流一直占用连接。
+func (c *CosineClient) do(req *http.Request) (*http.Response, error) {
+	t := &c.endpoint.transport
+	for k, v := range t.Headers {
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+	if t.UserAgent != "" {
+		req.Header.Set("User-Agent", t.UserAgent)
 	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	switch {
+	case err != nil:
+		cancel()
+		c.endpoint.health.recordFailure(err)
+		return nil, err
+	case resp.StatusCode >= http.StatusInternalServerError:
+		c.endpoint.health.recordFailure(fmt.Errorf("upstream status %d", resp.StatusCode))
+	default:
+		c.endpoint.health.recordSuccess()
+	}
+
+	resp.Body = newIdleTimeoutBody(resp.Body, t.IdleTimeout, cancel)
+	return resp, nil
+}
+
+// idleTimeoutBody 在两次读取之间超过 timeout 时取消请求，关闭时释放请求的 context
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+	cancel   context.CancelFunc
 }
 
-// SendChatRequest 发送聊天请求到 Cosine API，返回响应体供流式处理
-func (c *CosineClient) SendChatRequest(req *models.CosineChatRequest, auth string) (*http.Response, error) {
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, timeout: timeout, cancel: cancel}
+	if timeout > 0 {
+		b.timer = time.AfterFunc(timeout, func() {
+			b.timedOut.Store(true)
+			cancel()
+		})
+	}
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.timedOut.Load() {
+		return n, fmt.Errorf("no data from upstream for %s", b.timeout)
+	}
+	if b.timer != nil && n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// SendChatRequest 发送聊天请求到 Cosine API，返回响应体供流式处理。
+// span 在收到响应头时结束，读取响应体另行记录。
+func (c *CosineClient) SendChatRequest(ctx context.Context, req *models.CosineChatRequest, auth string) (resp *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "cosine.SendChatRequest",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("upstream.endpoint", c.endpoint.Name),
+			attribute.String("cosine.model", req.Model),
+		),
+	)
+	defer func() {
+		if err != nil {
+			tracing.RecordError(span, err)
+		} else {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+			if resp.StatusCode >= http.StatusBadRequest {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		}
+		span.End()
+	}()
+
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", c.baseURL+"/chat", bytes.NewReader(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/chat", bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -40,7 +157,7 @@ func (c *CosineClient) SendChatRequest(req *models.CosineChatRequest, auth strin
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Cookie", fmt.Sprintf("auth=%s", auth))
 
-	resp, err := c.httpClient.Do(httpReq)
+	resp, err = c.do(httpReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -48,6 +165,37 @@ func (c *CosineClient) SendChatRequest(req *models.CosineChatRequest, auth strin
 	return resp, nil
 }
 
+// ListModels 查询账户可用的模型列表
+func (c *CosineClient) ListModels(ctx context.Context, auth string) ([]string, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/models", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Cookie", fmt.Sprintf("auth=%s", auth))
+
+	resp, err := c.do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+
+	var list models.CosineModelsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode models: %w", err)
+	}
+	names := make([]string, 0, len(list.Models))
+	for _, m := range list.Models {
+		if m.ID != "" {
+			names = append(names, m.ID)
+		}
+	}
+	return names, nil
+}
+
 // ParseCosineStream 解析 Cosine 的自定义流式格式
 // 返回一个 channel 用于接收解析后的内容
 func ParseCosineStream(reader io.Reader) (<-chan StreamEvent, <-chan error) {
@@ -0,0 +1,324 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cosine/config"
+)
+
+const (
+	// failureThreshold 次连续失败后端点被视为不健康
+	failureThreshold = 3
+	// unhealthyCooldown 后允许再次尝试不健康的端点
+	unhealthyCooldown = 30 * time.Second
+)
+
+// ErrUnknownUpstream 表示账户固定的端点不在配置中
+var ErrUnknownUpstream = errors.New("unknown upstream endpoint")
+
+// Endpoint 是一个 Cosine 兼容的上游端点
+type Endpoint struct {
+	Name    string
+	BaseURL string
+	config  config.UpstreamEndpointConfig
+	// transport 是所有端点共用的连接设置
+	transport config.TransportConfig
+	health    *endpointHealth
+
+	// clientsMu 保护 clients，按代理地址缓存客户端以复用连接，空字符串为端点默认代理
+	clientsMu sync.Mutex
+	clients   map[string]*http.Client
+}
+
+// endpointHealth 记录端点的连续失败，配置重载后保留
+type endpointHealth struct {
+	mu            sync.Mutex
+	failures      int
+	lastError     string
+	lastFailureAt time.Time
+	lastSuccessAt time.Time
+	retryAt       time.Time
+}
+
+// EndpointStatus 是端点的健康状态
+type EndpointStatus struct {
+	Name                string     `json:"name"`
+	BaseURL             string     `json:"base_url"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at"`
+	LastSuccessAt       *time.Time `json:"last_success_at"`
+}
+
+var (
+	endpoints atomic.Pointer[[]*Endpoint]
+
+	// healthMu 保护 healths
+	healthMu sync.Mutex
+	healths  = make(map[string]*endpointHealth)
+)
+
+// Init 根据配置创建上游端点，配置重载时重建
+func Init(cfg *config.UpstreamConfig) error {
+	list, err := buildEndpoints(cfg)
+	if err != nil {
+		return err
+	}
+	endpoints.Store(&list)
+	setExternalTransport(cfg.Transport)
+
+	config.Subscribe(func(old, new *config.Config) {
+		setExternalTransport(new.Upstream.Transport)
+		list, err := buildEndpoints(&new.Upstream)
+		if err != nil {
+			slog.Error("Failed to rebuild upstream endpoints, keeping the current ones", "error", err)
+			return
+		}
+		// 旧端点上进行中的请求不受影响，只关闭空闲连接
+		for _, e := range loaded() {
+			e.closeIdleConnections()
+		}
+		endpoints.Store(&list)
+	})
+	return nil
+}
+
+func buildEndpoints(cfg *config.UpstreamConfig) ([]*Endpoint, error) {
+	var list []*Endpoint
+	for _, ec := range cfg.AllEndpoints() {
+		e := &Endpoint{
+			Name:      ec.Name,
+			BaseURL:   ec.BaseURL,
+			config:    ec,
+			transport: cfg.Transport,
+			health:    healthOf(ec.Name),
+			clients:   make(map[string]*http.Client),
+		}
+		// 提前创建默认客户端，使 TLS 等配置错误在启动或重载时暴露
+		if _, err := e.client(""); err != nil {
+			return nil, fmt.Errorf("upstream endpoint %q: %w", ec.Name, err)
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+func healthOf(name string) *endpointHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	h, ok := healths[name]
+	if !ok {
+		h = &endpointHealth{}
+		healths[name] = h
+	}
+	return h
+}
+
+// client 返回使用指定代理的客户端，proxy 为空时依次使用端点代理、全局代理和环境变量
+func (e *Endpoint) client(proxy string) (*http.Client, error) {
+	e.clientsMu.Lock()
+	defer e.clientsMu.Unlock()
+	if c, ok := e.clients[proxy]; ok {
+		return c, nil
+	}
+
+	c, err := e.newHTTPClient(proxy)
+	if err != nil {
+		return nil, err
+	}
+	e.clients[proxy] = c
+	return c, nil
+}
+
+func (e *Endpoint) closeIdleConnections() {
+	e.clientsMu.Lock()
+	defer e.clientsMu.Unlock()
+	for _, c := range e.clients {
+		c.CloseIdleConnections()
+	}
+}
+
+// newHTTPClient 按全局连接设置和端点的超时、代理、TLS 设置创建客户端
+func (e *Endpoint) newHTTPClient(proxy string) (*http.Client, error) {
+	transport, err := newTransport(&e.transport, proxy, e.config.Proxy, e.transport.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if e.config.Timeout > 0 {
+		transport.ResponseHeaderTimeout = e.config.Timeout
+	}
+
+	if tc := e.config.TLS; tc != (config.UpstreamTLSConfig{}) {
+		tlsConfig := &tls.Config{
+			ServerName:         tc.ServerName,
+			InsecureSkipVerify: tc.InsecureSkipVerify,
+		}
+		if tc.CAFile != "" {
+			pem, err := os.ReadFile(tc.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ca_file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", tc.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// newTransport 按全局连接设置创建 Transport，使用 proxies 中第一个非空的代理，
+// 都为空时使用环境变量中的代理
+func newTransport(t *config.TransportConfig, proxies ...string) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   t.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   t.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+		IdleConnTimeout:       t.IdleConnTimeout,
+		MaxIdleConns:          t.MaxIdleConns,
+		MaxIdleConnsPerHost:   t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       t.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	for _, p := range proxies {
+		if p == "" {
+			continue
+		}
+		if err := config.ValidateProxyURL(p); err != nil {
+			return nil, err
+		}
+		proxyURL, err := url.Parse(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+		break
+	}
+	return transport, nil
+}
+
+// loaded 返回当前的端点列表，Init 之前为空
+func loaded() []*Endpoint {
+	if list := endpoints.Load(); list != nil {
+		return *list
+	}
+	return nil
+}
+
+// Known 判断账户固定的端点是否存在，未固定时总是存在
+func Known(name string) bool {
+	if name == "" {
+		return true
+	}
+	return slices.ContainsFunc(loaded(), func(e *Endpoint) bool { return e.Name == name })
+}
+
+// Select 选择端点：固定了端点的账户只使用该端点；否则按配置顺序选择
+// 第一个健康且不在 avoid 中的端点，都不满足时依次放宽条件
+func Select(pinned string, avoid ...string) (*Endpoint, error) {
+	list := loaded()
+	if pinned != "" {
+		for _, e := range list {
+			if e.Name == pinned {
+				return e, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUpstream, pinned)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no upstream endpoints configured")
+	}
+
+	now := time.Now()
+	for _, e := range list {
+		if e.health.healthy(now) && !slices.Contains(avoid, e.Name) {
+			return e, nil
+		}
+	}
+	for _, e := range list {
+		if !slices.Contains(avoid, e.Name) {
+			return e, nil
+		}
+	}
+	return list[0], nil
+}
+
+// Status 返回所有端点的健康状态
+func Status() []EndpointStatus {
+	list := loaded()
+	now := time.Now()
+	result := make([]EndpointStatus, len(list))
+	for i, e := range list {
+		result[i] = e.health.status(now)
+		result[i].Name = e.Name
+		result[i].BaseURL = e.BaseURL
+	}
+	return result
+}
+
+func (h *endpointHealth) healthy(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures < failureThreshold || !now.Before(h.retryAt)
+}
+
+func (h *endpointHealth) recordSuccess() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	h.lastSuccessAt = time.Now()
+	recordOutcome(true)
+}
+
+func (h *endpointHealth) recordFailure(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastError = err.Error()
+	h.lastFailureAt = time.Now()
+	if h.failures >= failureThreshold {
+		h.retryAt = h.lastFailureAt.Add(unhealthyCooldown)
+	}
+	recordOutcome(false)
+}
+
+func (h *endpointHealth) status(now time.Time) EndpointStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := EndpointStatus{
+		Healthy:             h.failures < failureThreshold,
+		ConsecutiveFailures: h.failures,
+		LastError:           h.lastError,
+	}
+	if !h.lastFailureAt.IsZero() {
+		t := h.lastFailureAt
+		s.LastFailureAt = &t
+	}
+	if !h.lastSuccessAt.IsZero() {
+		t := h.lastSuccessAt
+		s.LastSuccessAt = &t
+	}
+	return s
+}
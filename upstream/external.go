@@ -0,0 +1,47 @@
+package upstream
+
+import (
+	"net/http"
+	"sync"
+
+	"cosine/config"
+	"cosine/models"
+)
+
+var (
+	// externalMu 保护 externalTransport 和 externalClients
+	externalMu        sync.Mutex
+	externalTransport config.TransportConfig
+	// externalClients 按代理地址缓存发往 Cosine 以外服务的客户端
+	externalClients = make(map[string]*http.Client)
+)
+
+// setExternalTransport 更新全局连接设置，丢弃按旧设置创建的客户端
+func setExternalTransport(t config.TransportConfig) {
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	for _, c := range externalClients {
+		c.CloseIdleConnections()
+	}
+	externalTransport = t
+	externalClients = make(map[string]*http.Client)
+}
+
+// ExternalClient 返回以账户身份访问 Cosine 以外服务（如续期会话令牌）的客户端。
+// 它使用全局连接设置和账户代理，账户没有代理时使用全局代理和环境变量；
+// 不使用端点的超时、代理和 TLS 设置，也不受端点健康状态影响
+func ExternalClient(account *models.Account) (*http.Client, error) {
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	if c, ok := externalClients[account.Proxy]; ok {
+		return c, nil
+	}
+
+	transport, err := newTransport(&externalTransport, account.Proxy, externalTransport.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	c := &http.Client{Transport: transport}
+	externalClients[account.Proxy] = c
+	return c, nil
+}
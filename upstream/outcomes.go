@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// outcomeBucket 是统计近期请求结果的时间粒度
+	outcomeBucket = 10 * time.Second
+	// MaxOutcomeWindow 是 RecentOutcomes 能统计的最长时间窗口
+	MaxOutcomeWindow = time.Hour
+)
+
+type outcomeCounts struct {
+	slot      int64
+	succeeded int
+	failed    int
+}
+
+// outcomes 按 10 秒分桶记录所有端点的请求结果，环形复用一小时的桶
+var outcomes struct {
+	mu      sync.Mutex
+	buckets [MaxOutcomeWindow / outcomeBucket]outcomeCounts
+}
+
+func recordOutcome(ok bool) {
+	slot := time.Now().UnixNano() / int64(outcomeBucket)
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+	b := &outcomes.buckets[slot%int64(len(outcomes.buckets))]
+	if b.slot != slot {
+		*b = outcomeCounts{slot: slot}
+	}
+	if ok {
+		b.succeeded++
+	} else {
+		b.failed++
+	}
+}
+
+// RecentOutcomes 返回最近 window 内上游请求的成功和失败次数，
+// 失败指网络错误和 5xx 响应，window 最长为 MaxOutcomeWindow
+func RecentOutcomes(window time.Duration) (succeeded, failed int) {
+	window = min(window, MaxOutcomeWindow)
+	now := time.Now().UnixNano() / int64(outcomeBucket)
+	oldest := now - int64((window+outcomeBucket-1)/outcomeBucket) + 1
+
+	outcomes.mu.Lock()
+	defer outcomes.mu.Unlock()
+	for _, b := range outcomes.buckets {
+		if b.slot >= oldest && b.slot <= now {
+			succeeded += b.succeeded
+			failed += b.failed
+		}
+	}
+	return succeeded, failed
+}
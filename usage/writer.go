@@ -0,0 +1,92 @@
+package usage
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"cosine/database"
+)
+
+const (
+	queueSize     = 10000
+	batchSize     = 200
+	flushInterval = 2 * time.Second
+)
+
+var (
+	queue  = make(chan database.UsageRecord, queueSize)
+	done   = make(chan struct{})
+	mu     sync.RWMutex
+	closed bool
+)
+
+// Start runs the background writer that batches queued records into the usage ledger
+func Start() {
+	go run()
+}
+
+// Record queues a usage record without blocking. Records are dropped when the
+// queue is full so a slow database never stalls chat requests.
+func Record(r database.UsageRecord) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if closed {
+		return
+	}
+
+	select {
+	case queue <- r:
+	default:
+		slog.Warn("Usage queue full, dropping record", "linuxdo_id", r.LinuxDoID)
+	}
+}
+
+// Close stops accepting records and waits until the queued ones are written
+func Close() {
+	mu.Lock()
+	if closed {
+		mu.Unlock()
+		return
+	}
+	closed = true
+	close(queue)
+	mu.Unlock()
+
+	<-done
+}
+
+func run() {
+	defer close(done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]database.UsageRecord, 0, batchSize)
+	for {
+		select {
+		case r, ok := <-queue:
+			if !ok {
+				flush(batch)
+				return
+			}
+			batch = append(batch, r)
+			if len(batch) >= batchSize {
+				batch = flush(batch)
+			}
+		case <-ticker.C:
+			batch = flush(batch)
+		}
+	}
+}
+
+// flush writes a batch and returns it emptied for reuse
+func flush(batch []database.UsageRecord) []database.UsageRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := database.InsertUsageRecords(batch); err != nil {
+		slog.Error("Failed to write usage records", "count", len(batch), "error", err)
+	}
+	return batch[:0]
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"cosine/auth"
+	"cosine/config"
+	"cosine/database"
+)
+
+const usersUsage = `usage: cosine users <command> [options]
+
+commands:
+  list [-json] [-q query] [-limit n] [-offset n]
+  ban <linuxdo_id>...
+  unban <linuxdo_id>...`
+
+const keysUsage = `usage: cosine keys create -user <linuxdo_id> [-name name] [-json]`
+
+// runUsers implements the users subcommand
+func runUsers(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal(usersUsage)
+	}
+	if err := database.Open(&cfg.Database); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	err := usersCommand(args[0], args[1:])
+	database.Close()
+	exitOnError(err)
+}
+
+func usersCommand(cmd string, args []string) error {
+	switch cmd {
+	case "list":
+		return listUsers(args)
+	case "ban", "unban":
+		ids, err := parseIDs(args, usersUsage)
+		if err != nil {
+			return err
+		}
+		banned := cmd == "ban"
+		for _, id := range ids {
+			if err := database.SetLinuxDoUserBanned(id, banned); err != nil {
+				return fmt.Errorf("Failed to %s user %d: %w", cmd, id, err)
+			}
+			fmt.Printf("User %d %sned\n", id, cmd)
+		}
+		return nil
+	default:
+		return errors.New(usersUsage)
+	}
+}
+
+func listUsers(args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	asJSON := outputFlag(fs)
+	query := fs.String("q", "", "only users whose username or name contains this")
+	limit := fs.Int("limit", 50, "maximum number of users")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	users, err := database.ListLinuxDoUsers(*query, *limit, *offset)
+	if err != nil {
+		return fmt.Errorf("Failed to list users: %w", err)
+	}
+	if *asJSON {
+		return printJSON(users)
+	}
+
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		flags := ""
+		switch {
+		case u.Banned:
+			flags = "banned"
+		case u.IsAdmin:
+			flags = "admin"
+		}
+		rows[i] = []string{
+			strconv.Itoa(u.LinuxDoID),
+			u.Username,
+			u.Name,
+			strconv.Itoa(u.TrustLevel),
+			flags,
+			u.CreatedAt.Local().Format("2006-01-02 15:04"),
+		}
+	}
+	printTable([]string{"LINUXDO ID", "USERNAME", "NAME", "TRUST", "FLAGS", "CREATED"}, rows)
+	return nil
+}
+
+// runKeys implements the keys subcommand
+func runKeys(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "create" {
+		log.Fatal(keysUsage)
+	}
+
+	fs := flag.NewFlagSet("keys create", flag.ExitOnError)
+	asJSON := outputFlag(fs)
+	linuxDoID := fs.Int("user", 0, "LinuxDo ID of the key owner")
+	name := fs.String("name", "", "label shown in the key list")
+	fs.Parse(args[1:])
+	if *linuxDoID == 0 {
+		log.Fatal(keysUsage)
+	}
+
+	if err := database.Open(&cfg.Database); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	err := createKey(*linuxDoID, *name, *asJSON)
+	database.Close()
+	exitOnError(err)
+}
+
+func createKey(linuxDoID int, name string, asJSON bool) error {
+	// Keys act as their owner, so the owner must have signed in before
+	if _, err := database.GetLinuxDoUserByLinuxDoID(linuxDoID); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("User %d not found", linuxDoID)
+	} else if err != nil {
+		return fmt.Errorf("Failed to load user %d: %w", linuxDoID, err)
+	}
+
+	key, prefix, err := auth.GenerateAPIKey()
+	if err != nil {
+		return fmt.Errorf("Failed to generate api key: %w", err)
+	}
+	apiKey, err := database.CreateAPIKey(linuxDoID, name, auth.HashAPIKey(key), prefix)
+	if err != nil {
+		return fmt.Errorf("Failed to create api key: %w", err)
+	}
+
+	if asJSON {
+		return printJSON(map[string]any{"key": key, "api_key": apiKey})
+	}
+	printTable([]string{"ID", "USER", "NAME", "KEY"}, [][]string{
+		{strconv.Itoa(apiKey.ID), strconv.Itoa(apiKey.LinuxDoID), apiKey.Name, key},
+	})
+	fmt.Println("\nThe key is shown only once.")
+	return nil
+}